@@ -0,0 +1,161 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AccessLogConfig is a config for the access log.
+type AccessLogConfig struct {
+	// Enabled logs the requests to the main server.
+	Enabled bool `toml:"enabled"`
+
+	// Output is "stderr", "stdout" or the path of a file.
+	// The default is "stderr".
+	Output string `toml:"output"`
+
+	// Format is "json" or "text". The default is "json".
+	Format string `toml:"format"`
+
+	// Level is the level of the successful requests such as "debug".
+	// The default is "info". Client errors are logged at least at "warn",
+	// and server errors at "error".
+	Level string `toml:"level"`
+
+	// SampleRate is the fraction of the successful requests to log.
+	// A zero value means all, a negative value means none.
+	// Errors are always logged.
+	SampleRate float64 `toml:"sample_rate"`
+}
+
+// NewLogger creates a logger writing to the output of the config.
+func (c *AccessLogConfig) NewLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if c.Level != "" {
+		err := level.UnmarshalText([]byte(c.Level))
+		if err != nil {
+			return nil, fmt.Errorf("lambique: access log level: %v", err)
+		}
+	}
+
+	var w io.Writer
+	switch c.Output {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(c.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(c.Format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("lambique: unknown access log format %s", c.Format)
+	}
+}
+
+// accessLogger returns the logger of the access log of the application.
+// It must be called with app.mu held.
+func (app *App) accessLogger() *slog.Logger {
+	if app.AccessLogger == nil {
+		logger, err := app.config().AccessLog.NewLogger()
+		if err != nil {
+			log.Printf("lambique: %v; falling back to the default logger", err)
+			logger = slog.Default()
+		}
+		app.AccessLogger = logger
+	}
+	return app.AccessLogger
+}
+
+// AccessLog returns a middleware logging the requests to the logger
+// as configured by c.AccessLog.
+// The remote IP is resolved with c.TrustedProxies. See ClientIP.
+func AccessLog(logger *slog.Logger, c *Config) Middleware {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.AccessLog.Level))
+	rate := c.AccessLog.SampleRate
+	trusted := c.TrustedProxies
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := wrapResponseWriter(w)
+			path := r.URL.Path
+
+			defer func() {
+				v := recover()
+				defer func() {
+					if v != nil {
+						panic(v)
+					}
+				}()
+
+				status := rw.Status()
+				if v != nil && !rw.wroteHeader {
+					status = http.StatusInternalServerError
+				}
+				l := level
+				switch {
+				case status >= 500:
+					l = slog.LevelError
+				case status >= 400:
+					l = max(l, slog.LevelWarn)
+				case rate < 0 || (rate > 0 && rate < 1 && rand.Float64() >= rate):
+					return
+				}
+
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("path", path),
+					slog.String("route", patternOf(r)),
+					slog.Int("status", status),
+					slog.Int64("bytes", rw.size),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("remote_ip", ClientIP(r, trusted)),
+					slog.String("user_agent", r.UserAgent()),
+					slog.String("request_id", GetRequestID(r.Context())),
+				}
+				if span := GetSpan(r.Context()); span != nil {
+					attrs = append(attrs,
+						slog.String("trace_id", span.TraceID),
+						slog.String("span_id", span.SpanID),
+					)
+				}
+				logger.LogAttrs(r.Context(), l, "access", attrs...)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
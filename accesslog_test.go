@@ -0,0 +1,175 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestAccessLog(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}", textHandler("user"))
+	rt.Get("/fail", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+	SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {}))
+
+	tests := []struct {
+		name       string
+		sampleRate float64
+		path       string
+		want       map[string]interface{}
+	}{
+		{
+			name: "success",
+			path: "/users/42",
+			want: map[string]interface{}{
+				"level":      "INFO",
+				"msg":        "access",
+				"method":     "GET",
+				"path":       "/users/42",
+				"route":      "/users/{id}",
+				"status":     float64(200),
+				"bytes":      float64(4),
+				"remote_ip":  "198.51.100.1",
+				"user_agent": "test",
+				"request_id": "abc",
+			},
+		},
+		{
+			name: "not found",
+			path: "/posts",
+			want: map[string]interface{}{
+				"level":  "WARN",
+				"path":   "/posts",
+				"route":  "",
+				"status": float64(404),
+			},
+		},
+		{
+			name:       "sampled out",
+			sampleRate: -1,
+			path:       "/users/42",
+		},
+		{
+			name:       "server error",
+			sampleRate: -1,
+			path:       "/fail",
+			want: map[string]interface{}{
+				"level":  "ERROR",
+				"route":  "/fail",
+				"status": float64(500),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := defaultConfig()
+			c.AccessLog.Enabled = true
+			c.AccessLog.SampleRate = tt.sampleRate
+			c.TrustedProxies = mustIPNets(t, "10.0.0.0/8")
+			app := &App{
+				Mux:          rt,
+				Config:       c,
+				AccessLogger: slog.New(slog.NewJSONHandler(&buf, nil)),
+			}
+
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			r.Header.Set("X-Forwarded-For", "198.51.100.1")
+			r.Header.Set("User-Agent", "test")
+			r.Header.Set("X-Request-ID", "abc")
+			app.Handler().ServeHTTP(httptest.NewRecorder(), r)
+
+			if tt.want == nil {
+				if buf.Len() != 0 {
+					t.Errorf("logged %s, want nothing", buf.String())
+				}
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid log %q: %v", buf.String(), err)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+			if _, ok := got["duration"]; !ok {
+				t.Error("duration is not logged")
+			}
+		})
+	}
+}
+
+func TestAccessLogConfig_NewLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var c Config
+	_, err = toml.Decode(`
+trusted_proxies = ["10.0.0.0/8", "::1"]
+
+[access_log]
+enabled = true
+output = "`+dir+`/access.log"
+format = "text"
+level = "debug"
+sample_rate = 0.5
+`, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.TrustedProxies) != 2 || c.TrustedProxies[1].String() != "::1/128" {
+		t.Errorf("TrustedProxies = %v", c.TrustedProxies)
+	}
+	if c.AccessLog.SampleRate != 0.5 {
+		t.Errorf("SampleRate = %v, want %v", c.AccessLog.SampleRate, 0.5)
+	}
+
+	logger, err := c.AccessLog.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("access", "status", 200)
+	b, err := ioutil.ReadFile(dir + "/access.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("level=DEBUG msg=access status=200")) {
+		t.Errorf("log = %s", b)
+	}
+
+	for _, invalid := range []AccessLogConfig{{Level: "verbose"}, {Format: "xml"}} {
+		if _, err := invalid.NewLogger(); err == nil {
+			t.Errorf("NewLogger() with %+v succeeds", invalid)
+		}
+	}
+}
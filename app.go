@@ -14,30 +14,420 @@
 
 package lambique
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
 
 // App is an application.
 type App struct {
-	Mux http.Handler
+	// Mux handles the requests to the main server.
+	// http.DefaultServeMux is used if nil.
+	Mux    http.Handler
+	Config *Config
+
+	// NewHTTP3Server creates a HTTP/3 server for each server serving TLS,
+	// which serves on the UDP port of the same address.
+	// lambique has no HTTP/3 implementation, so that it adapts one such as
+	// http3.Server of quic-go. HTTP/3 is not served if nil.
+	NewHTTP3Server NewHTTP3ServerFunc
+
+	// Health reports liveness and readiness of the application.
+	// The endpoints are served by the main server. See Config.LivenessPath.
+	Health Health
+
+	// NotFound and MethodNotAllowed replace the plain text 404 and 405
+	// responses of the mux. They also serve the unmatched requests of a
+	// Router whose own handlers are nil.
+	// NotFoundHandler and MethodNotAllowedHandler are used if nil.
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+
+	// AccessLogger is the logger of the access log if it is enabled.
+	// If nil, it is created by Config.AccessLog.NewLogger.
+	AccessLogger *slog.Logger
+
+	// SpanExporter exports the spans if tracing is enabled.
+	// If nil, it is created by Config.Tracing.NewExporter.
+	SpanExporter SpanExporter
+
+	// Metrics records the metrics if they are enabled.
+	// If nil, it is created by NewMetrics.
+	Metrics *Metrics
+
+	// RateLimiter limits the requests if it is set or Config.RateLimit is
+	// enabled. If nil, it is created with the in-memory store.
+	// The route patterns are resolved by the mux before routing if it has
+	// the Handler method like Router and http.ServeMux. The patterns of the
+	// health and metrics endpoints are their paths.
+	RateLimiter *RateLimiter
+
+	mu         sync.Mutex
+	middleware Chain
+	handlers   map[string]http.Handler
+	running    []*runningServer
+}
+
+// runningServer is a server serving on the listener announced on addr.
+type runningServer struct {
+	name     string
+	addr     string
+	listener net.Listener
+	server   *http.Server
+
+	packetConn net.PacketConn
+	http3      HTTP3Server
 }
 
 // WithMux creates a new application with mux.
 func WithMux(mux http.Handler) *App {
 	return &App{
-		Mux: mux,
+		Mux:    mux,
+		Config: GetConfig(),
+	}
+}
+
+func (app *App) mux() http.Handler {
+	if app.Mux == nil {
+		return http.DefaultServeMux
+	}
+	return app.Mux
+}
+
+func (app *App) config() *Config {
+	if app.Config == nil {
+		return GetConfig()
+	}
+	return app.Config
+}
+
+// Handle registers the handler for the additional server with the name.
+// The server is configured by Config.Servers[name] and started by App.Start
+// together with the main server.
+func (app *App) Handle(name string, handler http.Handler) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if app.handlers == nil {
+		app.handlers = map[string]http.Handler{}
+	}
+	app.handlers[name] = handler
+}
+
+// Handler returns the handler of the main server.
+// It serves the health endpoints and the metrics endpoint, and delegates the
+// others to the mux, through the middleware returned by App.Chain.
+func (app *App) Handler() http.Handler {
+	notFound := app.NotFound
+	if notFound == nil {
+		notFound = NotFoundHandler()
 	}
+	methodNotAllowed := app.MethodNotAllowed
+	if methodNotAllowed == nil {
+		methodNotAllowed = MethodNotAllowedHandler()
+	}
+	mux := app.mux()
+	if rt, ok := mux.(*Router); ok {
+		// the router does not write plain text error pages to be replaced
+		mux = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt.serve(w, r, notFound, methodNotAllowed)
+		})
+	}
+	mux = replaceErrorPages(mux, notFound, methodNotAllowed)
+
+	c := app.config()
+	handler := mux
+	if c.LivenessPath != "" || c.ReadinessPath != "" {
+		liveness := app.Health.LivenessHandler()
+		readiness := app.Health.ReadinessHandler()
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case c.LivenessPath != "" && r.URL.Path == c.LivenessPath:
+				setPattern(r, c.LivenessPath)
+				liveness.ServeHTTP(w, r)
+			case c.ReadinessPath != "" && r.URL.Path == c.ReadinessPath:
+				setPattern(r, c.ReadinessPath)
+				readiness.ServeHTTP(w, r)
+			default:
+				mux.ServeHTTP(w, r)
+			}
+		})
+	}
+	return app.withContext(app.Chain().Then(app.withMetricsEndpoint("", handler)))
+}
+
+// endpointPattern returns the pattern of the health or metrics endpoint
+// served by App.Handler for r, or "" if r is for the mux.
+func (app *App) endpointPattern(r *http.Request) string {
+	c := app.config()
+	if p := r.URL.Path; p != "" && (p == c.LivenessPath || p == c.ReadinessPath || p == app.metricsPath("")) {
+		return p
+	}
+	return ""
+}
+
+// withContext returns a handler passing the config of the application to h
+// in the request context. See ConfigFromContext.
+// The context also holds the route pattern of the request. See setPattern.
+func (app *App) withContext(h http.Handler) http.Handler {
+	c := app.config()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithConfig(r.Context(), c)
+		ctx = context.WithValue(ctx, routeInfoKey{}, &routeInfo{})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type routeInfoKey struct{}
+
+// routeInfo holds the route pattern of a request for the middleware, since
+// the request seen by the mux is not the one of the middleware if a
+// middleware replaces it with http.Request.WithContext.
+type routeInfo struct {
+	pattern string
+}
+
+// setPattern sets the route pattern of r to pattern.
+func setPattern(r *http.Request, pattern string) {
+	r.Pattern = pattern
+	if info, ok := r.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+		info.pattern = pattern
+	}
+}
+
+// patternOf returns the route pattern of r after it is served.
+func patternOf(r *http.Request) string {
+	if info, ok := r.Context().Value(routeInfoKey{}).(*routeInfo); ok && info.pattern != "" {
+		return info.pattern
+	}
+	return r.Pattern
 }
 
 // Server creates a new server.
+// Timeouts and limits are taken from the config of the application.
+// It panics if the config is not valid. See Config.Validate.
 func (app *App) Server(addr string) *http.Server {
-	return &http.Server{
-		Addr:    addr,
-		Handler: app.Mux,
+	return newServer(addr, app.Handler(), &app.config().ServerConfig)
+}
+
+func newServer(addr string, handler http.Handler, sc *ServerConfig) *http.Server {
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       sc.ReadTimeout.orDefault(defaultReadTimeout),
+		ReadHeaderTimeout: sc.ReadHeaderTimeout.orDefault(defaultReadHeaderTimeout),
+		WriteTimeout:      sc.WriteTimeout.orDefault(defaultWriteTimeout),
+		IdleTimeout:       sc.IdleTimeout.orDefault(defaultIdleTimeout),
+		MaxHeaderBytes:    sc.MaxHeaderBytes,
+	}
+	if s.MaxHeaderBytes == 0 {
+		s.MaxHeaderBytes = defaultMaxHeaderBytes
 	}
+	if sc.DisableKeepAlives {
+		s.SetKeepAlivesEnabled(false)
+	}
+	setProtocols(s, sc)
+	return s
 }
 
-// Start serves a HTTP server.
+// Start serves a HTTP server on addr, or Config.Addr if addr is empty, and
+// the additional servers registered by App.Handle.
+// Each server serves HTTPS instead if TLS is configured.
+// It fails without serving if any of the servers cannot listen, and
+// shuts down all the servers if any of them stops.
 func (app *App) Start(addr string) error {
-	s := app.Server(addr)
-	return s.ListenAndServe()
+	c := app.config()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = c.Addr
+	}
+	if addr == "" {
+		addr = ":http"
+		if c.TLS.Enabled() {
+			addr = ":https"
+		}
+	}
+
+	app.mu.Lock()
+	handlers := map[string]http.Handler{}
+	for name, h := range app.handlers {
+		handlers[name] = h
+	}
+	app.mu.Unlock()
+	if name := c.Metrics.Server; c.Metrics.Enabled && name != "" {
+		handlers[name] = app.withMetricsEndpoint(name, handlers[name])
+	}
+	var names []string
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var servers []*runningServer
+	closeAll := func() {
+		for _, rs := range servers {
+			rs.listener.Close()
+			if rs.packetConn != nil {
+				rs.packetConn.Close()
+			}
+		}
+	}
+
+	rs, err := app.prepare("", addr, app.Handler(), &c.ServerConfig)
+	if err != nil {
+		return err
+	}
+	servers = append(servers, rs)
+
+	for _, name := range names {
+		sc, ok := c.Servers[name]
+		if !ok || sc.Addr == "" {
+			closeAll()
+			return fmt.Errorf("lambique: no address for server %s", name)
+		}
+		rs, err := app.prepare(name, sc.Addr, app.withContext(Recover(handlers[name])), sc)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("lambique: server %s: %v", name, err)
+		}
+		servers = append(servers, rs)
+	}
+
+	app.track(servers...)
+
+	errs := make(chan error, len(servers))
+	for _, rs := range servers {
+		go func(rs *runningServer) {
+			errs <- app.run(rs)
+		}(rs)
+	}
+	notifyReady()
+
+	err = <-errs
+	if err != http.ErrServerClosed {
+		ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout.orDefault(defaultShutdownTimeout))
+		defer cancel()
+		app.Shutdown(ctx)
+	}
+	return err
+}
+
+// Serve serves a HTTP server on the listener.
+// It serves HTTPS instead if TLS is configured.
+func (app *App) Serve(ln net.Listener) error {
+	if err := app.config().Validate(); err != nil {
+		ln.Close()
+		return err
+	}
+	rs, err := app.serverOn("", ln.Addr().String(), ln, app.Handler(), &app.config().ServerConfig)
+	if err != nil {
+		return err
+	}
+	app.track(rs)
+	notifyReady()
+	return app.run(rs)
+}
+
+// prepare listens on addr and creates a server for it.
+func (app *App) prepare(name, addr string, handler http.Handler, sc *ServerConfig) (*runningServer, error) {
+	ln, err := app.listen(addr, sc)
+	if err != nil {
+		return nil, err
+	}
+	return app.serverOn(name, addr, ln, handler, sc)
+}
+
+func (app *App) serverOn(name, addr string, ln net.Listener, handler http.Handler, sc *ServerConfig) (*runningServer, error) {
+	s := newServer(addr, handler, sc)
+
+	if sc.TLS.Enabled() {
+		tc, err := sc.TLS.Load()
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		s.TLSConfig = tc
+	}
+
+	rs := &runningServer{
+		name:     name,
+		addr:     addr,
+		listener: ln,
+		server:   s,
+	}
+
+	if app.NewHTTP3Server != nil && s.TLSConfig != nil {
+		conn, h3, err := app.listenHTTP3(ln.Addr().String(), s)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		rs.packetConn = conn
+		rs.http3 = h3
+	}
+
+	return rs, nil
+}
+
+func (app *App) track(servers ...*runningServer) {
+	app.mu.Lock()
+	app.running = append(app.running, servers...)
+	app.mu.Unlock()
+}
+
+func (app *App) run(rs *runningServer) error {
+	if rs.http3 != nil {
+		go rs.http3.Serve(rs.packetConn)
+	}
+	if rs.server.TLSConfig != nil {
+		return rs.server.ServeTLS(rs.listener, "", "")
+	}
+	return rs.server.Serve(rs.listener)
+}
+
+// Shutdown gracefully shuts down all servers of the application.
+// The readiness check fails from the beginning, and the servers keep
+// serving for Config.ShutdownDelay so that load balancers can drain them.
+// See http.Server.Shutdown.
+func (app *App) Shutdown(ctx context.Context) error {
+	app.Health.beginShutdown()
+
+	if d := time.Duration(app.config().ShutdownDelay); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+
+	app.mu.Lock()
+	running := app.running
+	app.running = nil
+	app.mu.Unlock()
+
+	errs := make(chan error, len(running))
+	for _, rs := range running {
+		go func(rs *runningServer) {
+			if rs.http3 != nil {
+				rs.http3.Close()
+				rs.packetConn.Close()
+			}
+			errs <- rs.server.Shutdown(ctx)
+		}(rs)
+	}
+
+	var err error
+	for range running {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
@@ -0,0 +1,229 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApp_Server(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   *http.Server
+	}{
+		{
+			name:   "defaults",
+			config: defaultConfig(),
+			want: &http.Server{
+				Addr:              ":8080",
+				ReadTimeout:       defaultReadTimeout,
+				ReadHeaderTimeout: defaultReadHeaderTimeout,
+				WriteTimeout:      defaultWriteTimeout,
+				IdleTimeout:       defaultIdleTimeout,
+				MaxHeaderBytes:    defaultMaxHeaderBytes,
+			},
+		},
+		{
+			name:   "zero values",
+			config: &Config{},
+			want: &http.Server{
+				Addr:              ":8080",
+				ReadTimeout:       defaultReadTimeout,
+				ReadHeaderTimeout: defaultReadHeaderTimeout,
+				WriteTimeout:      defaultWriteTimeout,
+				IdleTimeout:       defaultIdleTimeout,
+				MaxHeaderBytes:    defaultMaxHeaderBytes,
+			},
+		},
+		{
+			name: "configured",
+			config: &Config{
+				ServerConfig: ServerConfig{
+					ReadTimeout:       Duration(5 * time.Second),
+					ReadHeaderTimeout: Duration(time.Second),
+					WriteTimeout:      Duration(-1),
+					IdleTimeout:       Duration(time.Minute),
+					MaxHeaderBytes:    4096,
+				},
+			},
+			want: &http.Server{
+				Addr:              ":8080",
+				ReadTimeout:       5 * time.Second,
+				ReadHeaderTimeout: time.Second,
+				WriteTimeout:      -1,
+				IdleTimeout:       time.Minute,
+				MaxHeaderBytes:    4096,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{Mux: http.NewServeMux(), Config: tt.config}
+			got := app.Server(":8080")
+			if got.Addr != tt.want.Addr {
+				t.Errorf("App.Server().Addr = %v, want %v", got.Addr, tt.want.Addr)
+			}
+			if got.ReadTimeout != tt.want.ReadTimeout {
+				t.Errorf("App.Server().ReadTimeout = %v, want %v", got.ReadTimeout, tt.want.ReadTimeout)
+			}
+			if got.ReadHeaderTimeout != tt.want.ReadHeaderTimeout {
+				t.Errorf("App.Server().ReadHeaderTimeout = %v, want %v", got.ReadHeaderTimeout, tt.want.ReadHeaderTimeout)
+			}
+			if got.WriteTimeout != tt.want.WriteTimeout {
+				t.Errorf("App.Server().WriteTimeout = %v, want %v", got.WriteTimeout, tt.want.WriteTimeout)
+			}
+			if got.IdleTimeout != tt.want.IdleTimeout {
+				t.Errorf("App.Server().IdleTimeout = %v, want %v", got.IdleTimeout, tt.want.IdleTimeout)
+			}
+			if got.MaxHeaderBytes != tt.want.MaxHeaderBytes {
+				t.Errorf("App.Server().MaxHeaderBytes = %v, want %v", got.MaxHeaderBytes, tt.want.MaxHeaderBytes)
+			}
+		})
+	}
+}
+
+func TestApp_Start_multipleServers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	publicSock := filepath.Join(dir, "public.sock")
+	adminSock := filepath.Join(dir, "admin.sock")
+
+	c := defaultConfig()
+	c.Addr = "unix:" + publicSock
+	c.Servers = map[string]*ServerConfig{
+		"admin": {Addr: "unix:" + adminSock},
+	}
+	app := &App{Mux: textHandler("public"), Config: c}
+	app.Handle("admin", textHandler("admin"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Start("")
+	}()
+
+	if got := getUnix(t, publicSock); got != "public" {
+		t.Errorf("public server response = %v, want %v", got, "public")
+	}
+	if got := getUnix(t, adminSock); got != "admin" {
+		t.Errorf("admin server response = %v, want %v", got, "admin")
+	}
+
+	err = app.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("App.Shutdown() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Errorf("App.Start() error = %v, want %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("App.Start() does not return after App.Shutdown()")
+	}
+	for _, sock := range []string{publicSock, adminSock} {
+		if _, err := os.Stat(sock); !os.IsNotExist(err) {
+			t.Errorf("%s is not removed", sock)
+		}
+	}
+}
+
+func TestApp_Start_failFast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	publicSock := filepath.Join(dir, "public.sock")
+	notSocket := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(notSocket, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig()
+	c.Servers = map[string]*ServerConfig{
+		"admin": {Addr: "unix:" + notSocket},
+	}
+	app := &App{Mux: textHandler("public"), Config: c}
+	app.Handle("admin", textHandler("admin"))
+	app.Handle("metrics", textHandler("metrics"))
+
+	err = app.Start("unix:" + publicSock)
+	if err == nil {
+		t.Fatalf("App.Start() succeeded, want error")
+	}
+	if _, err := os.Stat(publicSock); !os.IsNotExist(err) {
+		t.Errorf("listener of the main server is not closed")
+	}
+}
+
+func TestApp_Handler_defaultServeMux(t *testing.T) {
+	http.Handle("/lambique/default-serve-mux", textHandler("default"))
+
+	rec := httptest.NewRecorder()
+	WithMux(nil).Server(":0").Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/lambique/default-serve-mux", nil))
+	if got := rec.Body.String(); got != "default" {
+		t.Errorf("body = %v, want %v", got, "default")
+	}
+}
+
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(text))
+	})
+}
+
+func getUnix(t *testing.T, path string) string {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = client.Get("http://unix/")
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
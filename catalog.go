@@ -0,0 +1,201 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Message is a localized message of a problem type.
+type Message struct {
+	Title string `toml:"title"`
+	// Detail is a text/template executed with the extension members of
+	// ErrorResponse and "detail" member holding the original detail.
+	Detail string `toml:"detail"`
+}
+
+// catalogFile is the format of a message catalog file.
+//
+//	language = "ja"
+//
+//	[problems."https://example.com/probs/out-of-credit"]
+//	title = "クレジットが不足しています。"
+//	detail = "残高は{{.balance}}です。"
+//
+//	[problems."404"]
+//	title = "見つかりません"
+//
+// Problems are keyed by the problem type URI, or the status code for "about:blank".
+type catalogFile struct {
+	Language string             `toml:"language"`
+	Problems map[string]Message `toml:"problems"`
+}
+
+type compiledMessage struct {
+	lang   string
+	title  string
+	detail *template.Template
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = map[string]map[string]*compiledMessage{}
+)
+
+// AddMessage adds the message of the problem type in the language to the catalog.
+// key is the problem type URI, or the status code for "about:blank".
+func AddMessage(lang, key string, m Message) error {
+	cm := &compiledMessage{lang: lang, title: m.Title}
+	if m.Detail != "" {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(m.Detail)
+		if err != nil {
+			return err
+		}
+		cm.detail = tmpl
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	lang = strings.ToLower(lang)
+	if catalog[lang] == nil {
+		catalog[lang] = map[string]*compiledMessage{}
+	}
+	catalog[lang][key] = cm
+	return nil
+}
+
+// LoadCatalogs loads the message catalog files matching the patterns.
+func LoadCatalogs(patterns ...string) error {
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			err := loadCatalog(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func loadCatalog(path string) error {
+	var f catalogFile
+	_, err := toml.DecodeFile(path, &f)
+	if err != nil {
+		return err
+	}
+	if f.Language == "" {
+		return fmt.Errorf("%s: language is not specified", path)
+	}
+	for key, m := range f.Problems {
+		err := AddMessage(f.Language, key, m)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+	}
+	return nil
+}
+
+// Localize returns a copy of the ErrorResponse with the title and the detail
+// in the most preferred language of the Accept-Language header, falling
+// back from "ja-JP" to "ja" and then to Config.DefaultLanguage of GetConfig.
+// It returns the ErrorResponse itself and "" if no message is found.
+// ErrorResponse.Write falls back to the config of the request instead.
+// See ConfigFromContext.
+func (resp *ErrorResponse) Localize(acceptLanguage string) (*ErrorResponse, string) {
+	return resp.localize(acceptLanguage, GetConfig().DefaultLanguage)
+}
+
+func (resp *ErrorResponse) localize(acceptLanguage, defaultLanguage string) (*ErrorResponse, string) {
+	key := resp.Type
+	if key == "about:blank" {
+		key = strconv.Itoa(resp.Status)
+	}
+
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	for _, lang := range languageFallbacks(acceptLanguage, defaultLanguage) {
+		m, ok := catalog[lang][key]
+		if !ok {
+			continue
+		}
+
+		localized := *resp
+		if m.title != "" {
+			localized.Title = m.title
+		}
+		if m.detail != nil {
+			data := map[string]interface{}{}
+			for name, value := range resp.Extensions {
+				data[name] = value
+			}
+			data["detail"] = resp.Detail
+
+			var buf bytes.Buffer
+			if err := m.detail.Execute(&buf, data); err == nil {
+				localized.Detail = buf.String()
+			}
+		}
+		return &localized, m.lang
+	}
+	return resp, ""
+}
+
+// languageFallbacks returns the languages to look up in order.
+func languageFallbacks(acceptLanguage, defaultLanguage string) []string {
+	ranges := parseAccept(acceptLanguage)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	var langs []string
+	seen := map[string]bool{}
+	add := func(lang string) {
+		if lang != "" && !seen[lang] {
+			seen[lang] = true
+			langs = append(langs, lang)
+		}
+	}
+	for _, r := range ranges {
+		if r.q == 0 || r.value == "*" {
+			continue
+		}
+		tag := r.value
+		for {
+			add(tag)
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	add(strings.ToLower(defaultLanguage))
+	return langs
+}
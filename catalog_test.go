@@ -0,0 +1,186 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestErrorResponse_Write_localized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testcatalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "ja.toml"), []byte(`
+language = "ja"
+
+[problems."https://example.com/probs/localized"]
+title = "クレジットが不足しています。"
+detail = "残高は{{.balance}}です。"
+
+[problems."418"]
+title = "私はティーポットです"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "en-GB.toml"), []byte(`
+language = "en-GB"
+
+[problems."https://example.com/probs/localized"]
+title = "You do not have enough credit, mate."
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = LoadCatalogs(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typed := &ErrorResponse{
+		Type:       "https://example.com/probs/localized",
+		Title:      "You do not have enough credit.",
+		Status:     http.StatusForbidden,
+		Detail:     "Your current balance is 30.",
+		Extensions: map[string]interface{}{"balance": 30},
+	}
+	teapot := &ErrorResponse{
+		Type:   "about:blank",
+		Title:  "I'm a teapot",
+		Status: http.StatusTeapot,
+	}
+
+	tests := []struct {
+		name           string
+		resp           *ErrorResponse
+		acceptLanguage string
+		wantLanguage   string
+		wantTitle      string
+		wantDetail     string
+	}{
+		{
+			name:           "japanese",
+			resp:           typed,
+			acceptLanguage: "ja-JP, en;q=0.5",
+			wantLanguage:   "ja",
+			wantTitle:      "クレジットが不足しています。",
+			wantDetail:     "残高は30です。",
+		},
+		{
+			name:           "british",
+			resp:           typed,
+			acceptLanguage: "en-GB, ja;q=0.5",
+			wantLanguage:   "en-GB",
+			wantTitle:      "You do not have enough credit, mate.",
+			wantDetail:     "Your current balance is 30.",
+		},
+		{
+			name:           "not translated",
+			resp:           typed,
+			acceptLanguage: "fr",
+			wantLanguage:   "",
+			wantTitle:      "You do not have enough credit.",
+			wantDetail:     "Your current balance is 30.",
+		},
+		{
+			name:           "status",
+			resp:           teapot,
+			acceptLanguage: "ja",
+			wantLanguage:   "ja",
+			wantTitle:      "私はティーポットです",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+			rec := httptest.NewRecorder()
+
+			err := tt.resp.Write(rec, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rec.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %v, want %v", got, tt.wantLanguage)
+			}
+			got := &ErrorResponse{}
+			err = json.NewDecoder(rec.Body).Decode(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != tt.wantTitle {
+				t.Errorf("title = %v, want %v", got.Title, tt.wantTitle)
+			}
+			if got.Detail != tt.wantDetail {
+				t.Errorf("detail = %v, want %v", got.Detail, tt.wantDetail)
+			}
+		})
+	}
+
+	t.Run("default language of the app", func(t *testing.T) {
+		c := defaultConfig()
+		c.DefaultLanguage = "ja"
+		app := &App{
+			Mux: HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				return WithStatus(errors.New("short and stout"), http.StatusTeapot)
+			}),
+			Config: c,
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", "fr")
+		rec := httptest.NewRecorder()
+		app.Handler().ServeHTTP(rec, r)
+
+		if got := rec.Header().Get("Content-Language"); got != "ja" {
+			t.Errorf("Content-Language = %v, want %v", got, "ja")
+		}
+	})
+}
+
+func Test_languageFallbacks(t *testing.T) {
+	tests := []struct {
+		name            string
+		acceptLanguage  string
+		defaultLanguage string
+		want            []string
+	}{
+		{"empty", "", "", nil},
+		{"default", "", "en", []string{"en"}},
+		{"subtags", "zh-Hant-TW", "en", []string{"zh-hant-tw", "zh-hant", "zh", "en"}},
+		{"quality", "en;q=0.5, ja", "", []string{"ja", "en"}},
+		{"excluded", "ja, fr;q=0, *", "en", []string{"ja", "en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := languageFallbacks(tt.acceptLanguage, tt.defaultLanguage); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("languageFallbacks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPNet is a network which can be decoded from an address such as
+// "10.0.0.1" or a CIDR range such as "10.0.0.0/8".
+type IPNet struct {
+	*net.IPNet
+}
+
+// UnmarshalText parses text as an address or a CIDR range.
+func (n *IPNet) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("lambique: invalid address %q", s)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		n.IPNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return err
+	}
+	n.IPNet = ipnet
+	return nil
+}
+
+// MarshalText formats the network in the CIDR notation.
+func (n IPNet) MarshalText() ([]byte, error) {
+	if n.IPNet == nil {
+		return nil, nil
+	}
+	return []byte(n.IPNet.String()), nil
+}
+
+// ClientIP returns the address of the client of the request.
+// If the peer is one of the trusted proxies, the X-Forwarded-For header is
+// read from the right, skipping the trusted proxies, so that the client
+// cannot spoof its address by sending the header.
+func ClientIP(r *http.Request, trusted []IPNet) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(v, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			// a malformed entry cannot be trusted
+			return ip
+		}
+		ip = forwarded[i]
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(addr string, trusted []IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.IPNet != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
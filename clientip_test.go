@@ -0,0 +1,94 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func mustIPNets(t *testing.T, addrs ...string) []IPNet {
+	t.Helper()
+	nets := make([]IPNet, len(addrs))
+	for i, addr := range addrs {
+		if err := nets[i].UnmarshalText([]byte(addr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nets
+}
+
+func TestIPNet_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{"192.168.1.10", "192.168.1.10/32", false},
+		{"::1", "::1/128", false},
+		{"fd00::/8", "fd00::/8", false},
+		{"localhost", "", true},
+		{"10.0.0.0/33", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var n IPNet
+			err := n.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, _ := n.MarshalText()
+			if string(got) != tt.want {
+				t.Errorf("UnmarshalText() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := mustIPNets(t, "10.0.0.0/8", "::1")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.1:1234", nil, "203.0.113.1"},
+		{"untrusted peer", "203.0.113.1:1234", []string{"198.51.100.1"}, "203.0.113.1"},
+		{"trusted peer", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed", "10.0.0.1:1234", []string{"192.0.2.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"multiple headers", "[::1]:1234", []string{"192.0.2.1", "198.51.100.1"}, "198.51.100.1"},
+		{"all trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"malformed", "10.0.0.1:1234", []string{"unknown"}, "10.0.0.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
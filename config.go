@@ -15,8 +15,11 @@
 package lambique
 
 import (
+	"context"
 	"os/user"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -24,16 +27,118 @@ import (
 var (
 	cfg         = defaultConfig()
 	defaultAddr = ":2697" // \u2697
+
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxHeaderBytes    = 1 << 20 // 1 MB
+	defaultKeepAlivePeriod   = 15 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultRequestIDHeader   = "X-Request-ID"
 )
 
 // Config is a config for web application.
 type Config struct {
+	// ServerConfig is the config of the main server.
+	ServerConfig
+
+	// Debug shows the details of server errors to the clients.
+	// It must not be enabled in production.
+	Debug bool `toml:"debug"`
+
+	// Catalogs are the glob patterns of the message catalog files.
+	// Relative patterns are relative to the directory of the config file.
+	// See LoadCatalogs.
+	Catalogs []string `toml:"catalogs"`
+
+	// DefaultLanguage is the language of the messages when none of the
+	// languages accepted by the client is in the catalogs.
+	DefaultLanguage string `toml:"default_language"`
+
+	// Servers are the configs of the additional servers by name.
+	// See App.Handle.
+	Servers map[string]*ServerConfig `toml:"servers"`
+
+	// ShutdownTimeout is how long to wait for active connections
+	// when the application is restarted.
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+
+	// ShutdownDelay is how long to keep serving after the readiness check
+	// begins to fail on shutdown.
+	ShutdownDelay Duration `toml:"shutdown_delay"`
+
+	// LivenessPath and ReadinessPath are the paths of the health endpoints
+	// such as "/healthz" and "/readyz".
+	// They are disabled by default so that they do not shadow the routes of
+	// the mux.
+	LivenessPath  string `toml:"liveness_path"`
+	ReadinessPath string `toml:"readiness_path"`
+
+	// RequestIDHeader is the header of the request ID. See RequestID.
+	// An empty header disables the request ID.
+	RequestIDHeader string `toml:"request_id_header"`
+
+	// TrustedProxies are the addresses or the CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header is trusted. See ClientIP.
+	TrustedProxies []IPNet `toml:"trusted_proxies"`
+
+	// AccessLog is the config of the access log of the main server.
+	AccessLog AccessLogConfig `toml:"access_log"`
+
+	// Tracing is the config of the tracing of the main server.
+	Tracing TracingConfig `toml:"tracing"`
+
+	// Metrics is the config of the metrics of the main server.
+	Metrics MetricsConfig `toml:"metrics"`
+
+	// CORS is the config of the cross-origin requests to the main server.
+	CORS CORSConfig `toml:"cors"`
+
+	// RateLimit is the config of the rate limiting of the main server.
+	RateLimit RateLimitConfig `toml:"rate_limit"`
+}
+
+// ServerConfig is a config for a server of the application.
+type ServerConfig struct {
+	// Addr is a TCP address, "unix:/path.sock" or "fd:3". See App.Listen.
 	Addr string `toml:"address"`
+
+	// Timeouts and limits of the server.
+	// A zero value means the default, a negative value means no timeout.
+	ReadTimeout       Duration `toml:"read_timeout"`
+	ReadHeaderTimeout Duration `toml:"read_header_timeout"`
+	WriteTimeout      Duration `toml:"write_timeout"`
+	IdleTimeout       Duration `toml:"idle_timeout"`
+	MaxHeaderBytes    int      `toml:"max_header_bytes"`
+
+	// Keep-alive settings.
+	// KeepAlivePeriod is the TCP keep-alive period of accepted connections.
+	DisableKeepAlives bool     `toml:"disable_keep_alives"`
+	KeepAlivePeriod   Duration `toml:"keep_alive_period"`
+
+	// SocketMode is the octal permission of a Unix domain socket such as "0660".
+	SocketMode string `toml:"socket_mode"`
+
+	TLS TLSConfig `toml:"tls"`
+
+	// H2C enables HTTP/2 over cleartext TCP alongside HTTP/1.1.
+	H2C bool `toml:"h2c"`
 }
 
 func defaultConfig() *Config {
 	return &Config{
-		Addr: defaultAddr,
+		ServerConfig: ServerConfig{
+			Addr:              defaultAddr,
+			ReadTimeout:       Duration(defaultReadTimeout),
+			ReadHeaderTimeout: Duration(defaultReadHeaderTimeout),
+			WriteTimeout:      Duration(defaultWriteTimeout),
+			IdleTimeout:       Duration(defaultIdleTimeout),
+			MaxHeaderBytes:    defaultMaxHeaderBytes,
+			KeepAlivePeriod:   Duration(defaultKeepAlivePeriod),
+		},
+		ShutdownTimeout: Duration(defaultShutdownTimeout),
+		RequestIDHeader: defaultRequestIDHeader,
 	}
 }
 
@@ -51,10 +156,77 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	var catalogs []string
+	for _, pattern := range cfg.Catalogs {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		catalogs = append(catalogs, pattern)
+	}
+	err = LoadCatalogs(catalogs...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
+// Validate reports an error if the config is not valid.
+// LoadConfig, App.Start and App.Serve validate the config.
+func (c *Config) Validate() error {
+	return c.CORS.Validate()
+}
+
 // GetConfig returns the config.
 func GetConfig() *Config {
 	return cfg
 }
+
+type configKey struct{}
+
+// WithConfig returns a copy of ctx with the config.
+// App passes its config to the handlers with it.
+func WithConfig(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, c)
+}
+
+// ConfigFromContext returns the config in ctx, or the config returned by
+// GetConfig if there is none.
+func ConfigFromContext(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey{}).(*Config); ok && c != nil {
+		return c
+	}
+	return GetConfig()
+}
+
+// Duration is a time.Duration which can be decoded from a string
+// such as "30s" or "1m30s".
+type Duration time.Duration
+
+// UnmarshalText parses text as a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// orDefault returns d as a time.Duration, or def if d is zero.
+func (d Duration) orDefault(def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
@@ -18,7 +18,11 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -85,3 +89,125 @@ func TestGetConfig(t *testing.T) {
 		t.Errorf("Config.Addr -> %s, want %s", cfg2.Addr, newAddr)
 	}
 }
+
+func TestLoadConfig_serverOptions(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "testconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(`
+read_timeout = "5s"
+read_header_timeout = "2s"
+write_timeout = "1m"
+idle_timeout = "-1s"
+max_header_bytes = 4096
+disable_keep_alives = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// LoadConfig decodes into the config returned by GetConfig
+	defer func(c *Config) { cfg = c }(cfg)
+	cfg = defaultConfig()
+
+	c, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := defaultConfig()
+	want.ReadTimeout = Duration(5 * time.Second)
+	want.ReadHeaderTimeout = Duration(2 * time.Second)
+	want.WriteTimeout = Duration(time.Minute)
+	want.IdleTimeout = Duration(-time.Second)
+	want.MaxHeaderBytes = 4096
+	want.DisableKeepAlives = true
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("LoadConfig() = %#v, want %#v", c, want)
+	}
+}
+
+func TestDuration_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Duration
+		wantErr bool
+	}{
+		{
+			name: "seconds",
+			text: "30s",
+			want: Duration(30 * time.Second),
+		},
+		{
+			name: "compound",
+			text: "1m30s",
+			want: Duration(90 * time.Second),
+		},
+		{
+			name:    "invalid",
+			text:    "30",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Duration
+			err := d.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Duration.UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if d != tt.want {
+				t.Errorf("Duration.UnmarshalText() = %v, want %v", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_servers(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "testconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(`
+address = ":8080"
+
+[servers.admin]
+address = "127.0.0.1:9090"
+read_timeout = "1s"
+
+[servers.admin.tls]
+cert_file = "admin.pem"
+key_file = "admin-key.pem"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig()
+	_, err = toml.DecodeFile(tmpfile.Name(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Addr != ":8080" {
+		t.Errorf("Config.Addr = %v, want %v", c.Addr, ":8080")
+	}
+	want := &ServerConfig{
+		Addr:        "127.0.0.1:9090",
+		ReadTimeout: Duration(time.Second),
+		TLS: TLSConfig{
+			CertFile: "admin.pem",
+			KeyFile:  "admin-key.pem",
+		},
+	}
+	if !reflect.DeepEqual(c.Servers["admin"], want) {
+		t.Errorf("Config.Servers[admin] = %#v, want %#v", c.Servers["admin"], want)
+	}
+}
@@ -0,0 +1,253 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	defaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+
+	// the pagination headers
+	defaultCORSExposedHeaders = []string{"Link", "X-Total-Count"}
+)
+
+// CORSConfig is a config for the cross-origin resource sharing.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to access the server such as
+	// "https://example.com". "*" allows any origin, and a wildcard
+	// subdomain such as "https://*.example.com" allows the subdomains.
+	// An empty list disables CORS.
+	AllowedOrigins []string `toml:"allowed_origins"`
+
+	// AllowedMethods are the methods allowed by the preflight requests.
+	// The default is GET, HEAD and POST.
+	AllowedMethods []string `toml:"allowed_methods"`
+
+	// AllowedHeaders are the request headers allowed by the preflight
+	// requests. "*" allows any header.
+	// The default is Accept, Accept-Language, Content-Language and Content-Type.
+	AllowedHeaders []string `toml:"allowed_headers"`
+
+	// ExposedHeaders are the response headers readable by the clients.
+	// The default is Link and X-Total-Count for the pagination.
+	ExposedHeaders []string `toml:"exposed_headers"`
+
+	// AllowCredentials allows the requests with credentials such as cookies.
+	// It cannot be used with the "*" origin.
+	AllowCredentials bool `toml:"allow_credentials"`
+
+	// MaxAge is how long the result of the preflight request can be cached.
+	// A zero value leaves it to the client.
+	MaxAge Duration `toml:"max_age"`
+}
+
+// Enabled reports whether any origin is allowed.
+func (c *CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// Validate reports an error if the config is unsafe.
+func (c *CORSConfig) Validate() error {
+	if !c.AllowCredentials {
+		return nil
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			// any site could read the responses on behalf of the users
+			return errors.New(`lambique: CORS allows credentials for the "*" origin`)
+		}
+	}
+	return nil
+}
+
+// CORS returns a middleware handling the cross-origin requests as
+// configured by c. Preflight requests are answered without calling the
+// handler, and the ones from disallowed origins, or with disallowed methods
+// or headers, are answered with a 403 ErrorResponse.
+// It panics if c is not valid. See CORSConfig.Validate.
+func CORS(c *CORSConfig) Middleware {
+	if err := c.Validate(); err != nil {
+		panic(err)
+	}
+	p := newCORSPolicy(c)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if preflight {
+				h.Add("Vary", "Origin")
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				if err := p.preflight(h, origin, r); err != nil {
+					NewErrorResponse(r, Forbidden(err), 0).Write(w, r)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if !p.anyOrigin {
+				h.Add("Vary", "Origin")
+			}
+			if origin != "" && p.allowsOrigin(origin) {
+				p.setOrigin(h, origin)
+				if p.exposed != "" {
+					h.Set("Access-Control-Expose-Headers", p.exposed)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsPolicy is the compiled CORSConfig.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   [][2]string // prefix and suffix
+	methods     map[string]bool
+	allMethods  string
+	anyHeader   bool
+	headers     map[string]bool
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(c *CORSConfig) *corsPolicy {
+	p := &corsPolicy{
+		origins:     map[string]bool{},
+		methods:     map[string]bool{},
+		headers:     map[string]bool{},
+		credentials: c.AllowCredentials,
+	}
+
+	for _, o := range c.AllowedOrigins {
+		o = strings.ToLower(o)
+		switch {
+		case o == "*":
+			p.anyOrigin = true
+		case strings.Count(o, "*") == 1:
+			i := strings.Index(o, "*")
+			p.wildcards = append(p.wildcards, [2]string{o[:i], o[i+1:]})
+		default:
+			p.origins[o] = true
+		}
+	}
+
+	allowed := c.AllowedMethods
+	if allowed == nil {
+		allowed = defaultCORSMethods
+	}
+	var methods []string
+	for _, m := range allowed {
+		m = strings.ToUpper(m)
+		methods = append(methods, m)
+		p.methods[m] = true
+	}
+	p.allMethods = strings.Join(methods, ", ")
+
+	headers := c.AllowedHeaders
+	if headers == nil {
+		headers = defaultCORSHeaders
+	}
+	for _, h := range headers {
+		if h == "*" {
+			p.anyHeader = true
+		}
+		p.headers[strings.ToLower(h)] = true
+	}
+
+	exposed := c.ExposedHeaders
+	if exposed == nil {
+		exposed = defaultCORSExposedHeaders
+	}
+	p.exposed = strings.Join(exposed, ", ")
+
+	if d := time.Duration(c.MaxAge); d > 0 {
+		p.maxAge = strconv.Itoa(int(d / time.Second))
+	}
+	return p
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.anyOrigin || p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		// the wildcard matches at least one character
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight sets the headers of the response to the preflight request,
+// or returns the reason why it is not allowed.
+func (p *corsPolicy) preflight(h http.Header, origin string, r *http.Request) error {
+	if !p.allowsOrigin(origin) {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !p.methods[method] {
+		return fmt.Errorf("method %s is not allowed", method)
+	}
+	var requested []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if !p.anyHeader && !p.headers[name] {
+				return fmt.Errorf("header %s is not allowed", name)
+			}
+			requested = append(requested, name)
+		}
+	}
+
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.allMethods)
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	return nil
+}
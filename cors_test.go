@@ -0,0 +1,244 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestCORS(t *testing.T) {
+	var c Config
+	_, err := toml.Decode(`
+[cors]
+allowed_origins = ["https://app.example.com", "https://*.example.net"]
+allowed_methods = ["get", "post", "delete"]
+allowed_headers = ["Content-Type", "Authorization"]
+allow_credentials = true
+max_age = "10m"
+`, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		want       map[string]string
+	}{
+		{
+			name:       "same origin",
+			method:     "GET",
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:       "allowed origin",
+			method:     "GET",
+			header:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "Link, X-Total-Count",
+			},
+		},
+		{
+			name:       "wildcard subdomain",
+			method:     "POST",
+			header:     map[string]string{"Origin": "https://a.b.example.net"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "https://a.b.example.net",
+			},
+		},
+		{
+			name:       "wildcard does not match the parent",
+			method:     "GET",
+			header:     map[string]string{"Origin": "https://example.net"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:       "suffix attack",
+			method:     "GET",
+			header:     map[string]string{"Origin": "https://evil-example.net"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:   "preflight",
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                         "https://api.example.net",
+				"Access-Control-Request-Method":  "DELETE",
+				"Access-Control-Request-Headers": "authorization, content-type",
+			},
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://api.example.net",
+				"Access-Control-Allow-Methods":     "GET, POST, DELETE",
+				"Access-Control-Allow-Headers":     "authorization, content-type",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name:   "preflight from disallowed origin",
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			wantStatus: http.StatusForbidden,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Content-Type":                "application/problem+json",
+			},
+		},
+		{
+			name:   "preflight with disallowed method",
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "PUT",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "preflight with disallowed header",
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "X-Debug",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "plain options",
+			method:     "OPTIONS",
+			header:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "https://app.example.com",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c
+			c.ServerConfig = defaultConfig().ServerConfig
+			app := &App{Mux: textHandler("ok"), Config: &c}
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			app.Handler().ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			for k, v := range tt.want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %v, want %v", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCORS_anyOrigin(t *testing.T) {
+	h := CORS(&CORSConfig{
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{},
+		MaxAge:         Duration(-time.Second),
+	})(textHandler("ok"))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	h.ServeHTTP(rec, r)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %v, want %v", got, "*")
+	}
+	if got := rec.Header()["Vary"]; got != nil {
+		t.Errorf("Vary = %v, want none", got)
+	}
+	if _, ok := rec.Header()["Access-Control-Expose-Headers"]; ok {
+		t.Error("Access-Control-Expose-Headers is set")
+	}
+}
+
+func TestCORSConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *CORSConfig
+		wantErr bool
+	}{
+		{"any origin", &CORSConfig{AllowedOrigins: []string{"*"}}, false},
+		{"credentials", &CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, false},
+		{"any origin with credentials", &CORSConfig{AllowedOrigins: []string{"https://example.com", "*"}, AllowCredentials: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("CORS does not panic for the invalid config")
+		}
+	}()
+	CORS(&CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestApp_Start_invalidCORS(t *testing.T) {
+	c := defaultConfig()
+	c.CORS = CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	app := &App{Mux: textHandler("ok"), Config: c}
+
+	if err := app.Start("127.0.0.1:0"); err == nil {
+		t.Errorf("App.Start() succeeded, want error")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Serve(ln); err == nil {
+		t.Errorf("App.Serve() succeeded, want error")
+	}
+}
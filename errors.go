@@ -1,11 +1,38 @@
 package lambique
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
+// Media types of ErrorResponse.
+const (
+	mediaTypeProblemJSON = "application/problem+json"
+	mediaTypeProblemXML  = "application/problem+xml"
+	mediaTypeJSON        = "application/json"
+	mediaTypeXML         = "application/xml"
+	mediaTypeText        = "text/plain"
+)
+
+// problemMediaTypes are the media types supported by ErrorResponse.Write
+// in the order of preference.
+var problemMediaTypes = []string{
+	mediaTypeProblemJSON,
+	mediaTypeJSON,
+	mediaTypeProblemXML,
+	mediaTypeXML,
+	mediaTypeText,
+}
+
 // ErrorResponse represents an error based on RFC7807.
 type ErrorResponse struct {
 	Type     string `json:"type"`
@@ -13,24 +40,195 @@ type ErrorResponse struct {
 	Status   int    `json:"status"`
 	Detail   string `json:"detail"`
 	Instance string `json:"instance"`
+
+	// Extensions are the extension members of the problem.
+	// They are flattened into the top-level JSON object.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// reservedMembers are the members defined by RFC7807.
+var reservedMembers = map[string]bool{
+	"type":     true,
+	"title":    true,
+	"status":   true,
+	"detail":   true,
+	"instance": true,
 }
 
 // NewErrorResponse creates a new ErrorResponse.
+// The type and title are taken from the problem type declared by err.
+// A zero status means the status for err. See StatusOf.
+//
+// The detail of a server error is replaced with a generic one, and err is
+// reported with the correlation ID set as "correlation_id" member.
+// Config.Debug of the request disables hiding the detail.
+// See ConfigFromContext.
+// The request ID and the trace ID are set as "request_id" and "trace_id"
+// members if any. See RequestID and Trace.
 func NewErrorResponse(r *http.Request, err error, status int) *ErrorResponse {
-	return &ErrorResponse{
+	resp := &ErrorResponse{
 		Type:     "about:blank",
-		Title:    fmt.Sprintf("%s", err),
 		Status:   status,
 		Detail:   fmt.Sprintf("%s", err),
 		Instance: r.RequestURI,
 	}
+
+	if pt, ok := problemTypeOf(err); ok {
+		resp.Type = pt.URI
+		resp.Title = pt.Title
+	}
+	if resp.Status == 0 {
+		resp.Status = StatusOf(err)
+	}
+
+	var extender ProblemExtender
+	if errors.As(err, &extender) {
+		for name, value := range extender.ProblemExtensions() {
+			resp.Set(name, value)
+		}
+	}
+	if resp.Title == "" {
+		resp.Title = http.StatusText(resp.Status)
+	}
+	if id := GetRequestID(r.Context()); id != "" {
+		resp.Set("request_id", id)
+	}
+	if span := GetSpan(r.Context()); span != nil {
+		resp.Set("trace_id", span.TraceID)
+	}
+
+	if resp.Status >= 500 {
+		id := newCorrelationID()
+		reportError(r, id, err, debug.Stack())
+		resp.Set("correlation_id", id)
+		if !ConfigFromContext(r.Context()).Debug {
+			resp.Detail = internalErrorDetail
+		}
+	}
+
+	return resp
+}
+
+// Set sets the extension member.
+// It returns an error if the name is one of the standard members.
+func (resp *ErrorResponse) Set(name string, value interface{}) error {
+	if reservedMembers[name] {
+		return fmt.Errorf("lambique: %s is a reserved member", name)
+	}
+	if resp.Extensions == nil {
+		resp.Extensions = map[string]interface{}{}
+	}
+	resp.Extensions[name] = value
+	return nil
+}
+
+// Extension decodes the extension member into v.
+// It reports false if the member does not exist.
+func (resp *ErrorResponse) Extension(name string, v interface{}) (bool, error) {
+	value, ok := resp.Extensions[name]
+	if !ok {
+		return false, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return true, err
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+// MarshalJSON encodes the ErrorResponse with the extension members.
+// Extension members named as the standard members are ignored.
+func (resp ErrorResponse) MarshalJSON() ([]byte, error) {
+	type errorResponse ErrorResponse
+	b, err := json.Marshal(errorResponse(resp))
+	if err != nil || len(resp.Extensions) == 0 {
+		return b, err
+	}
+
+	var names []string
+	for name := range resp.Extensions {
+		if !reservedMembers[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	for _, name := range names {
+		k, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(resp.Extensions[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes the ErrorResponse and its extension members.
+func (resp *ErrorResponse) UnmarshalJSON(b []byte) error {
+	type errorResponse ErrorResponse
+	err := json.Unmarshal(b, (*errorResponse)(resp))
+	if err != nil {
+		return err
+	}
+
+	var members map[string]interface{}
+	err = json.Unmarshal(b, &members)
+	if err != nil {
+		return err
+	}
+	resp.Extensions = nil
+	for name, value := range members {
+		if !reservedMembers[name] {
+			resp.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// Write writes the ErrorResponse in the media type negotiated with the
+// Accept header of r.
+// It writes JSON if none of the supported media types is acceptable.
+// The title and the detail are localized for the Accept-Language header.
+func (resp *ErrorResponse) Write(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Language")
+
+	defaultLanguage := ConfigFromContext(r.Context()).DefaultLanguage
+	resp, lang := resp.localize(r.Header.Get("Accept-Language"), defaultLanguage)
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+
+	switch negotiateContentType(r.Header.Get("Accept"), problemMediaTypes) {
+	case mediaTypeProblemXML, mediaTypeXML:
+		return resp.XML(w)
+	case mediaTypeText:
+		return resp.Text(w)
+	}
+	return resp.JSON(w)
 }
 
 // JSON writes the ErrorResponse as JSON.
+// If it cannot be encoded, such as an extension member is NaN, a 500
+// ErrorResponse without the extension members is written instead and the
+// error is returned.
 func (resp *ErrorResponse) JSON(w http.ResponseWriter) error {
-	w.Header().Add("Content-type", "application/json")
-	w.WriteHeader(resp.Status)
-	return json.NewEncoder(w).Encode(resp)
+	b, err := json.Marshal(resp)
+	if err != nil {
+		b, _ = json.Marshal(resp.encodingFailure())
+		writeProblem(w, mediaTypeProblemJSON, http.StatusInternalServerError, append(b, '\n'))
+		return err
+	}
+	return writeProblem(w, mediaTypeProblemJSON, resp.Status, append(b, '\n'))
 }
 
 // MustJSON is like JSON but panics if the JSON encoder returns error.
@@ -40,3 +238,137 @@ func (resp *ErrorResponse) MustJSON(w http.ResponseWriter) {
 		panic(err)
 	}
 }
+
+// XML writes the ErrorResponse as XML defined in Appendix A of RFC7807.
+// If it cannot be encoded, a 500 ErrorResponse without the extension members
+// is written instead and the error is returned.
+func (resp *ErrorResponse) XML(w http.ResponseWriter) error {
+	b, err := resp.marshalXML()
+	if err != nil {
+		b, _ = resp.encodingFailure().marshalXML()
+		writeProblem(w, mediaTypeProblemXML, http.StatusInternalServerError, b)
+		return err
+	}
+	return writeProblem(w, mediaTypeProblemXML, resp.Status, b)
+}
+
+// encodingFailure returns the ErrorResponse written instead of resp if resp
+// cannot be encoded.
+func (resp *ErrorResponse) encodingFailure() *ErrorResponse {
+	return &ErrorResponse{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusInternalServerError),
+		Status:   http.StatusInternalServerError,
+		Detail:   "The server failed to encode the error response.",
+		Instance: resp.Instance,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, contentType string, status int, b []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err := w.Write(b)
+	return err
+}
+
+// marshalXML encodes the ErrorResponse as XML defined in Appendix A of RFC7807.
+// Extension members whose names are not valid XML names are ignored.
+func (resp *ErrorResponse) marshalXML() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<problem xmlns="urn:ietf:rfc:7807">`)
+
+	writeXMLElement(&buf, "type", resp.Type)
+	writeXMLElement(&buf, "title", resp.Title)
+	writeXMLElement(&buf, "status", float64(resp.Status))
+	writeXMLElement(&buf, "detail", resp.Detail)
+	writeXMLElement(&buf, "instance", resp.Instance)
+
+	if len(resp.Extensions) > 0 {
+		// normalize the values to the JSON data model
+		b, err := json.Marshal(resp.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		var members map[string]interface{}
+		err = json.Unmarshal(b, &members)
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for name := range members {
+			if !reservedMembers[name] && isXMLName(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeXMLElement(&buf, name, members[name])
+		}
+	}
+
+	buf.WriteString("</problem>\n")
+	return buf.Bytes(), nil
+}
+
+// Text writes the ErrorResponse as plain text.
+func (resp *ErrorResponse) Text(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", mediaTypeText+"; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(resp.Status)
+
+	_, err := fmt.Fprintf(w, "%d %s\n", resp.Status, resp.Title)
+	if err == nil && resp.Detail != "" {
+		_, err = fmt.Fprintf(w, "%s\n", resp.Detail)
+	}
+	return err
+}
+
+// writeXMLElement writes the value in the JSON data model as an element.
+// Arrays are written as "i" elements as RFC7807 does.
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}) {
+	buf.WriteString("<" + name + ">")
+	switch v := value.(type) {
+	case nil:
+	case string:
+		xml.EscapeText(buf, []byte(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case []interface{}:
+		for _, item := range v {
+			writeXMLElement(buf, "i", item)
+		}
+	case map[string]interface{}:
+		var names []string
+		for name := range v {
+			if isXMLName(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeXMLElement(buf, name, v[name])
+		}
+	default:
+		xml.EscapeText(buf, []byte(fmt.Sprint(v)))
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
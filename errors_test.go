@@ -0,0 +1,427 @@
+package lambique
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var testOutOfCreditType = RegisterProblemType(&ProblemType{
+	URI:    "https://example.com/probs/out-of-credit",
+	Title:  "You do not have enough credit.",
+	Status: http.StatusForbidden,
+})
+
+func TestNewErrorResponse(t *testing.T) {
+	type args struct {
+		err    error
+		status int
+	}
+	tests := []struct {
+		name string
+		args args
+		want *ErrorResponse
+	}{
+		{
+			name: "untyped",
+			args: args{
+				err:    errors.New("something wrong"),
+				status: http.StatusBadRequest,
+			},
+			want: &ErrorResponse{
+				Type:     "about:blank",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "something wrong",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "untyped without status",
+			args: args{
+				err: errors.New("something wrong"),
+			},
+			want: &ErrorResponse{
+				Type:     "about:blank",
+				Title:    "Internal Server Error",
+				Status:   http.StatusInternalServerError,
+				Detail:   internalErrorDetail,
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "classified without status",
+			args: args{
+				err: fmt.Errorf("user 42: %w", ErrNotFound),
+			},
+			want: &ErrorResponse{
+				Type:     "about:blank",
+				Title:    "Not Found",
+				Status:   http.StatusNotFound,
+				Detail:   "user 42: Not Found",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "registered type",
+			args: args{
+				err: testOutOfCreditType.Errorf("Your current balance is %d, but that costs %d.", 30, 50),
+			},
+			want: &ErrorResponse{
+				Type:     "https://example.com/probs/out-of-credit",
+				Title:    "You do not have enough credit.",
+				Status:   http.StatusForbidden,
+				Detail:   "Your current balance is 30, but that costs 50.",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "classified registered type",
+			args: args{
+				err: Conflict(testOutOfCreditType.Errorf("balance is 30")),
+			},
+			want: &ErrorResponse{
+				Type:     "https://example.com/probs/out-of-credit",
+				Title:    "You do not have enough credit.",
+				Status:   http.StatusConflict,
+				Detail:   "balance is 30",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "wrapped registered type with status",
+			args: args{
+				err:    fmt.Errorf("transfer: %w", testOutOfCreditType.Errorf("balance is 30")),
+				status: http.StatusPaymentRequired,
+			},
+			want: &ErrorResponse{
+				Type:     "https://example.com/probs/out-of-credit",
+				Title:    "You do not have enough credit.",
+				Status:   http.StatusPaymentRequired,
+				Detail:   "transfer: balance is 30",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "unregistered type",
+			args: args{
+				err:    WithProblemType(errors.New("gone"), "https://example.com/probs/unknown"),
+				status: http.StatusGone,
+			},
+			want: &ErrorResponse{
+				Type:     "https://example.com/probs/unknown",
+				Title:    "Gone",
+				Status:   http.StatusGone,
+				Detail:   "gone",
+				Instance: "/account/12345/msgs/abc",
+			},
+		},
+	}
+	SetErrorReporter(nil)
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/account/12345/msgs/abc", nil)
+			got := NewErrorResponse(r, tt.args.err, tt.args.status)
+			// random
+			delete(got.Extensions, "correlation_id")
+			if len(got.Extensions) == 0 {
+				got.Extensions = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewErrorResponse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewErrorResponse_serverError(t *testing.T) {
+	tests := []struct {
+		name       string
+		debug      bool
+		wantDetail string
+	}{
+		{
+			name:       "safe",
+			debug:      false,
+			wantDetail: internalErrorDetail,
+		},
+		{
+			name:       "debug",
+			debug:      true,
+			wantDetail: "pq: relation \"users\" does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.Debug = tt.debug
+
+			var reportedID string
+			var reportedErr error
+			SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {
+				reportedID, reportedErr = id, err
+			}))
+			defer SetErrorReporter(ErrorReporterFunc(logError))
+
+			cause := errors.New(`pq: relation "users" does not exist`)
+			r := httptest.NewRequest("GET", "/users", nil)
+			r = r.WithContext(WithConfig(r.Context(), c))
+			got := NewErrorResponse(r, cause, 0)
+
+			if got.Status != http.StatusInternalServerError {
+				t.Errorf("NewErrorResponse().Status = %v, want %v", got.Status, http.StatusInternalServerError)
+			}
+			if got.Title != "Internal Server Error" {
+				t.Errorf("NewErrorResponse().Title = %v, want %v", got.Title, "Internal Server Error")
+			}
+			if got.Detail != tt.wantDetail {
+				t.Errorf("NewErrorResponse().Detail = %v, want %v", got.Detail, tt.wantDetail)
+			}
+			if reportedErr != cause {
+				t.Errorf("reported error = %v, want %v", reportedErr, cause)
+			}
+			if id := got.Extensions["correlation_id"]; id == "" || id != reportedID {
+				t.Errorf("correlation_id = %v, want %v", id, reportedID)
+			}
+		})
+	}
+}
+
+func TestRegisterProblemType_duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterProblemType() with a registered URI does not panic")
+		}
+	}()
+	RegisterProblemType(&ProblemType{URI: testOutOfCreditType.URI})
+}
+
+func TestErrorResponse_MarshalJSON(t *testing.T) {
+	resp := &ErrorResponse{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   http.StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+		Extensions: map[string]interface{}{
+			"balance":  30,
+			"accounts": []string{"/account/12345", "/account/67890"},
+			"status":   "clobbered",
+		},
+	}
+	want := `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","accounts":["/account/12345","/account/67890"],"balance":30}`
+
+	got, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("json.Marshal() = %s, want %s", got, want)
+	}
+	// not addressable
+	wrapped, err := json.Marshal(map[string]ErrorResponse{"error": *resp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"error":` + want + `}`; string(wrapped) != want {
+		t.Errorf("json.Marshal() of a value = %s, want %s", wrapped, want)
+	}
+
+	decoded := &ErrorResponse{}
+	err = json.Unmarshal(got, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Status != http.StatusForbidden {
+		t.Errorf("decoded Status = %v, want %v", decoded.Status, http.StatusForbidden)
+	}
+	var balance int
+	ok, err := decoded.Extension("balance", &balance)
+	if !ok || err != nil || balance != 30 {
+		t.Errorf("decoded Extension(balance) = %v, %v, %v, want %v, true, nil", balance, ok, err, 30)
+	}
+	var accounts []string
+	ok, err = decoded.Extension("accounts", &accounts)
+	if !ok || err != nil || !reflect.DeepEqual(accounts, []string{"/account/12345", "/account/67890"}) {
+		t.Errorf("decoded Extension(accounts) = %v, %v, %v", accounts, ok, err)
+	}
+	if _, ok := decoded.Extensions["status"]; ok {
+		t.Errorf("decoded Extensions has the standard member status")
+	}
+}
+
+func TestErrorResponse_Set(t *testing.T) {
+	resp := &ErrorResponse{}
+	if err := resp.Set("trace_id", "abc"); err != nil {
+		t.Errorf("ErrorResponse.Set(trace_id) error = %v", err)
+	}
+	if err := resp.Set("detail", "abc"); err == nil {
+		t.Errorf("ErrorResponse.Set(detail) succeeded, want error")
+	}
+	want := map[string]interface{}{"trace_id": "abc"}
+	if !reflect.DeepEqual(resp.Extensions, want) {
+		t.Errorf("ErrorResponse.Extensions = %v, want %v", resp.Extensions, want)
+	}
+}
+
+func TestErrorResponse_Write(t *testing.T) {
+	resp := &ErrorResponse{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   http.StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+		Extensions: map[string]interface{}{
+			"balance":  30,
+			"accounts": []string{"/account/12345", "/account/67890"},
+		},
+	}
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "no accept",
+			accept:          "",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","accounts":["/account/12345","/account/67890"],"balance":30}` + "\n",
+		},
+		{
+			name:            "xml",
+			accept:          "application/problem+xml, application/problem+json;q=0.5",
+			wantContentType: "application/problem+xml",
+			wantBody:        xml.Header + `<problem xmlns="urn:ietf:rfc:7807"><type>https://example.com/probs/out-of-credit</type><title>You do not have enough credit.</title><status>403</status><detail>Your current balance is 30, but that costs 50.</detail><instance>/account/12345/msgs/abc</instance><accounts><i>/account/12345</i><i>/account/67890</i></accounts><balance>30</balance></problem>` + "\n",
+		},
+		{
+			name:            "text",
+			accept:          "text/*",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "403 You do not have enough credit.\nYour current balance is 30, but that costs 50.\n",
+		},
+		{
+			name:            "not acceptable",
+			accept:          "image/png",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","accounts":["/account/12345","/account/67890"],"balance":30}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/account/12345/msgs/abc", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "text/html")
+
+			err := resp.Write(w, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %v, want %v", w.Code, http.StatusForbidden)
+			}
+			if got := w.Header()["Content-Type"]; !reflect.DeepEqual(got, []string{tt.wantContentType}) {
+				t.Errorf("Content-Type = %v, want %v", got, tt.wantContentType)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestErrorResponse_Write_encodingFailure(t *testing.T) {
+	resp := &ErrorResponse{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   http.StatusForbidden,
+		Instance: "/account/12345/msgs/abc",
+	}
+	resp.Set("balance", math.NaN())
+
+	tests := []struct {
+		accept   string
+		wantBody string
+	}{
+		{"application/problem+json", `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"The server failed to encode the error response.","instance":"/account/12345/msgs/abc"}` + "\n"},
+		{"application/problem+xml", xml.Header + `<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><title>Internal Server Error</title><status>500</status><detail>The server failed to encode the error response.</detail><instance>/account/12345/msgs/abc</instance></problem>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/account/12345/msgs/abc", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			if err := resp.Write(w, r); err == nil {
+				t.Errorf("ErrorResponse.Write() succeeded, want error")
+			}
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %v, want %v", w.Code, http.StatusInternalServerError)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_negotiateContentType(t *testing.T) {
+	offers := []string{"application/problem+json", "application/problem+xml", "text/plain"}
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"empty", "", "application/problem+json"},
+		{"exact", "application/problem+xml", "application/problem+xml"},
+		{"wildcard", "*/*", "application/problem+json"},
+		{"quality", "application/problem+json;q=0.1, text/plain", "text/plain"},
+		{"specific over wildcard", "*/*;q=0.8, application/problem+json;q=0", "application/problem+xml"},
+		{"subtype wildcard", "text/*", "text/plain"},
+		{"not acceptable", "image/png", ""},
+		{"invalid quality", "text/plain;q=2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateContentType(tt.header, offers); got != tt.want {
+				t.Errorf("negotiateContentType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Handler_debug(t *testing.T) {
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+	SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {}))
+
+	c := defaultConfig()
+	c.Debug = true
+	app := &App{
+		Mux: HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return errors.New("connection refused")
+		}),
+		Config: c,
+	}
+
+	rec := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	var got ErrorResponse
+	if err := got.UnmarshalJSON(rec.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Detail != "connection refused" {
+		t.Errorf("detail = %v, want %v", got.Detail, "connection refused")
+	}
+}
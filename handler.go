@@ -0,0 +1,48 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+)
+
+// HandlerFunc is an adapter to use functions returning an error as http.Handler.
+// The returned error is written as ErrorResponse.
+// See NewErrorResponse for how the status and the problem type are chosen.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP calls f(w, r) and writes the returned error as ErrorResponse.
+// If the response has already been started, the error is reported instead.
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := wrapResponseWriter(w)
+
+	err := f(rw, r)
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// the client has gone
+		return
+	}
+	if rw.wroteHeader {
+		reportError(r, newCorrelationID(), err, debug.Stack())
+		return
+	}
+	NewErrorResponse(r, err, 0).Write(rw, r)
+}
@@ -0,0 +1,91 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerFunc_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name            string
+		handler         HandlerFunc
+		wantStatus      int
+		wantContentType string
+		wantReported    bool
+	}{
+		{
+			name: "no error",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				w.Write([]byte("ok"))
+				return nil
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name: "classified error",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return fmt.Errorf("user 42: %w", ErrNotFound)
+			},
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "application/problem+json",
+		},
+		{
+			name: "unknown error",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return errors.New("sql: connection refused")
+			},
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "application/problem+json",
+			wantReported:    true,
+		},
+		{
+			name: "error after response",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusAccepted)
+				return Conflict(errors.New("too late"))
+			},
+			wantStatus:   http.StatusAccepted,
+			wantReported: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reported := false
+			SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {
+				reported = true
+			}))
+			defer SetErrorReporter(ErrorReporterFunc(logError))
+
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/users/42", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %v, want %v", got, tt.wantContentType)
+			}
+			if reported != tt.wantReported {
+				t.Errorf("reported = %v, want %v", reported, tt.wantReported)
+			}
+		})
+	}
+}
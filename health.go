@@ -0,0 +1,208 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+var (
+	defaultHealthCheckTimeout = 5 * time.Second
+
+	errShuttingDown = errors.New("shutting down")
+)
+
+// CheckFunc checks a component of the application.
+// It returns an error if the component is not healthy.
+type CheckFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	name    string
+	timeout time.Duration
+	check   CheckFunc
+}
+
+// Health reports liveness and readiness of the application.
+// The zero value is ready to use.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  []*healthCheck
+	readiness []*healthCheck
+
+	shuttingDown int32
+}
+
+// HealthReport is the response body of the health endpoints.
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckReport `json:"checks,omitempty"`
+}
+
+// HealthCheckReport is a result of a check.
+// The error is shown only if Config.Debug is enabled, and reported by the
+// error reporter. See SetErrorReporter.
+type HealthCheckReport struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AddLivenessCheck registers the check for liveness.
+// A zero timeout means the default.
+func (h *Health) AddLivenessCheck(name string, timeout time.Duration, check CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, newHealthCheck(name, timeout, check))
+}
+
+// AddReadinessCheck registers the check for readiness.
+// A zero timeout means the default.
+func (h *Health) AddReadinessCheck(name string, timeout time.Duration, check CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, newHealthCheck(name, timeout, check))
+}
+
+func newHealthCheck(name string, timeout time.Duration, check CheckFunc) *healthCheck {
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	return &healthCheck{
+		name:    name,
+		timeout: timeout,
+		check:   check,
+	}
+}
+
+// LivenessHandler returns a handler reporting whether the application is alive.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := h.liveness
+		h.mu.RUnlock()
+
+		writeHealthReport(w, runHealthChecks(r, checks))
+	})
+}
+
+// ReadinessHandler returns a handler reporting whether the application is
+// ready to serve.
+// It reports failure once the application begins to shut down.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := h.readiness
+		h.mu.RUnlock()
+
+		if h.ShuttingDown() {
+			checks = append([]*healthCheck{{
+				name:  "shutdown",
+				check: func(context.Context) error { return errShuttingDown },
+			}}, checks...)
+		}
+
+		writeHealthReport(w, runHealthChecks(r, checks))
+	})
+}
+
+// ShuttingDown reports whether the application is shutting down.
+func (h *Health) ShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+func (h *Health) beginShutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func runHealthChecks(r *http.Request, checks []*healthCheck) *HealthReport {
+	report := &HealthReport{
+		Status: healthStatusOK,
+		Checks: make([]HealthCheckReport, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			report.Checks[i] = runHealthCheck(r, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, c := range report.Checks {
+		if c.Status != healthStatusOK {
+			report.Status = healthStatusFail
+		}
+	}
+	return report
+}
+
+func runHealthCheck(r *http.Request, c *healthCheck) HealthCheckReport {
+	ctx := r.Context()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err == nil {
+		return HealthCheckReport{Name: c.name, Status: healthStatusOK}
+	}
+	report := HealthCheckReport{Name: c.name, Status: healthStatusFail}
+	if err == errShuttingDown || ConfigFromContext(r.Context()).Debug {
+		report.Error = err.Error()
+	}
+	if err != errShuttingDown {
+		// the errors may contain the addresses of the internal services
+		reportError(r, "", fmt.Errorf("health check %s: %w", c.name, err), nil)
+	}
+	return report
+}
+
+func writeHealthReport(w http.ResponseWriter, report *HealthReport) {
+	status := http.StatusOK
+	if report.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
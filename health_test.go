@@ -0,0 +1,204 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealth_ReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       map[string]CheckFunc
+		shuttingDown bool
+		wantStatus   int
+		want         *HealthReport
+	}{
+		{
+			name:       "no checks",
+			wantStatus: http.StatusOK,
+			want:       &HealthReport{Status: "ok"},
+		},
+		{
+			name: "healthy",
+			checks: map[string]CheckFunc{
+				"db": func(context.Context) error { return nil },
+			},
+			wantStatus: http.StatusOK,
+			want: &HealthReport{
+				Status: "ok",
+				Checks: []HealthCheckReport{
+					{Name: "db", Status: "ok"},
+				},
+			},
+		},
+		{
+			name: "unhealthy",
+			checks: map[string]CheckFunc{
+				"db": func(context.Context) error { return errors.New("connection refused") },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want: &HealthReport{
+				Status: "fail",
+				Checks: []HealthCheckReport{
+					{Name: "db", Status: "fail", Error: "connection refused"},
+				},
+			},
+		},
+		{
+			name: "timeout",
+			checks: map[string]CheckFunc{
+				"slow": func(context.Context) error {
+					time.Sleep(time.Second)
+					return nil
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want: &HealthReport{
+				Status: "fail",
+				Checks: []HealthCheckReport{
+					{Name: "slow", Status: "fail", Error: "context deadline exceeded"},
+				},
+			},
+		},
+		{
+			name:         "shutting down",
+			shuttingDown: true,
+			wantStatus:   http.StatusServiceUnavailable,
+			want: &HealthReport{
+				Status: "fail",
+				Checks: []HealthCheckReport{
+					{Name: "shutdown", Status: "fail", Error: "shutting down"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Health
+			for name, check := range tt.checks {
+				h.AddReadinessCheck(name, 10*time.Millisecond, check)
+			}
+			if tt.shuttingDown {
+				h.beginShutdown()
+			}
+
+			c := defaultConfig()
+			c.Debug = true
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			rec := httptest.NewRecorder()
+			h.ReadinessHandler().ServeHTTP(rec, r.WithContext(WithConfig(r.Context(), c)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			got := &HealthReport{}
+			err := json.NewDecoder(rec.Body).Decode(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Handler_health(t *testing.T) {
+	c := defaultConfig()
+	c.LivenessPath = "/healthz"
+	c.ReadinessPath = "/readyz"
+	app := &App{Mux: textHandler("mux"), Config: c}
+	app.Health.AddLivenessCheck("goroutines", 0, func(context.Context) error { return nil })
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantType   string
+	}{
+		{"/healthz", http.StatusOK, "application/json"},
+		{"/readyz", http.StatusOK, "application/json"},
+		{"/foo", http.StatusOK, "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.Handler().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %v, want %v", got, tt.wantType)
+			}
+		})
+	}
+
+	app.Shutdown(context.Background())
+	rec := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readiness status after shutdown = %v, want %v", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestApp_Handler_healthDisabled(t *testing.T) {
+	app := &App{Mux: textHandler("mux"), Config: defaultConfig()}
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		app.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if got := rec.Body.String(); got != "mux" {
+			t.Errorf("%s: body = %v, want %v", path, got, "mux")
+		}
+	}
+}
+
+func TestHealth_LivenessHandler_hideError(t *testing.T) {
+	var reported error
+	SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {
+		reported = err
+	}))
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+
+	cause := errors.New("dial tcp 10.0.0.5:5432: connection refused")
+	var h Health
+	h.AddLivenessCheck("db", 0, func(context.Context) error { return cause })
+
+	rec := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	got := &HealthReport{}
+	err := json.NewDecoder(rec.Body).Decode(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &HealthReport{
+		Status: "fail",
+		Checks: []HealthCheckReport{{Name: "db", Status: "fail"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("body = %#v, want %#v", got, want)
+	}
+	if !errors.Is(reported, cause) {
+		t.Errorf("reported %v, want %v", reported, cause)
+	}
+}
@@ -0,0 +1,164 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	unixAddrPrefix = "unix:"
+	fdAddrPrefix   = "fd:"
+
+	// listenFDsStart is the first file descriptor passed by systemd.
+	listenFDsStart = 3
+)
+
+var defaultSocketMode os.FileMode = 0660
+
+// Listen announces on the local network address.
+//
+// The address is one of:
+//
+//	host:port     a TCP address
+//	unix:/path    a Unix domain socket, a stale socket file is removed
+//	fd:3          a listener inherited as the file descriptor
+//	fd:name       a listener passed by systemd socket activation with the name
+//	fd:           the first listener passed by systemd socket activation
+//
+// A listener passed on App.Restart is used if any.
+func (app *App) Listen(addr string) (net.Listener, error) {
+	return app.listen(addr, &app.config().ServerConfig)
+}
+
+func (app *App) listen(addr string, sc *ServerConfig) (net.Listener, error) {
+	if ln, ok, err := inheritedListener(addr); ok {
+		return ln, err
+	}
+
+	switch {
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		return listenUnix(strings.TrimPrefix(addr, unixAddrPrefix), sc.SocketMode)
+	case strings.HasPrefix(addr, fdAddrPrefix):
+		fd, err := inheritedFD(strings.TrimPrefix(addr, fdAddrPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return fileListener(fd, addr)
+	}
+
+	lc := net.ListenConfig{
+		KeepAlive: sc.KeepAlivePeriod.orDefault(defaultKeepAlivePeriod),
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func listenUnix(path, socketMode string) (net.Listener, error) {
+	mode := defaultSocketMode
+	if socketMode != "" {
+		m, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket mode %q: %v", socketMode, err)
+		}
+		mode = os.FileMode(m)
+	}
+
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, mode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file at path if no one is listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// inheritedFD returns the file descriptor specified by spec.
+// See sd_listen_fds(3) for the environment variables set by systemd.
+func inheritedFD(spec string) (uintptr, error) {
+	if fd, err := strconv.Atoi(spec); err == nil {
+		if fd < listenFDsStart {
+			return 0, fmt.Errorf("invalid file descriptor: %d", fd)
+		}
+		return uintptr(fd), nil
+	}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return 0, fmt.Errorf("no file descriptors passed to process %d", os.Getpid())
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("no file descriptors passed to process %d", os.Getpid())
+	}
+
+	if spec == "" {
+		return listenFDsStart, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i, name := range names {
+		if i < n && name == spec {
+			return uintptr(listenFDsStart + i), nil
+		}
+	}
+	return 0, fmt.Errorf("no file descriptor named %s", spec)
+}
+
+func fileListener(fd uintptr, name string) (net.Listener, error) {
+	f := os.NewFile(fd, name)
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	defer f.Close()
+
+	return net.FileListener(f)
+}
@@ -0,0 +1,197 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestApp_Listen_unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testlisten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.sock")
+	c := defaultConfig()
+	c.SocketMode = "0600"
+	app := &App{Mux: http.NewServeMux(), Config: c}
+
+	// leave a stale socket behind
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := app.Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("App.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// the socket is in use
+	_, err = app.Listen("unix:" + path)
+	if err == nil {
+		t.Errorf("App.Listen() on a socket in use succeeded, want error")
+	}
+
+	go app.Serve(ln)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestApp_Listen_notSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "testlisten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	app := &App{Mux: http.NewServeMux(), Config: defaultConfig()}
+	_, err = app.Listen("unix:" + f.Name())
+	if err == nil {
+		t.Errorf("App.Listen() on a regular file succeeded, want error")
+	}
+	if _, err := os.Stat(f.Name()); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
+
+func TestApp_Listen_fd(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// App.Listen takes the ownership of the file descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{Mux: http.NewServeMux(), Config: defaultConfig()}
+	ln, err := app.Listen(fmt.Sprintf("fd:%d", fd))
+	if err != nil {
+		t.Fatalf("App.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	if ln.Addr().String() != tcp.Addr().String() {
+		t.Errorf("App.Listen().Addr() = %v, want %v", ln.Addr(), tcp.Addr())
+	}
+}
+
+func Test_inheritedFD(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name    string
+		spec    string
+		env     map[string]string
+		want    uintptr
+		wantErr bool
+	}{
+		{
+			name: "number",
+			spec: "5",
+			want: 5,
+		},
+		{
+			name:    "stdio",
+			spec:    "1",
+			wantErr: true,
+		},
+		{
+			name: "systemd",
+			spec: "",
+			env:  map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2"},
+			want: 3,
+		},
+		{
+			name: "systemd named",
+			spec: "admin",
+			env:  map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2", "LISTEN_FDNAMES": "public:admin"},
+			want: 4,
+		},
+		{
+			name:    "systemd unknown name",
+			spec:    "metrics",
+			env:     map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2", "LISTEN_FDNAMES": "public:admin"},
+			wantErr: true,
+		},
+		{
+			name:    "other process",
+			spec:    "",
+			env:     map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "not activated",
+			spec:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got, err := inheritedFD(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("inheritedFD() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("inheritedFD() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsContentType is the content type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	defaultMetricsPath = "/metrics"
+
+	// DefaultDurationBuckets are the buckets of the latency histogram in seconds.
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// DefaultSizeBuckets are the buckets of the response size histogram in bytes.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+// MetricsConfig is a config for the metrics.
+type MetricsConfig struct {
+	// Enabled records the metrics of the main server.
+	Enabled bool `toml:"enabled"`
+
+	// Path is the path of the metrics endpoint. The default is "/metrics".
+	Path string `toml:"path"`
+
+	// Server is the name of the additional server serving the endpoint,
+	// such as an admin server bound to localhost.
+	// The main server serves it if empty. See App.Handle.
+	Server string `toml:"server"`
+}
+
+// Metrics records the metrics of the requests labelled by the method, the
+// route pattern and the status, and exposes them with the Go runtime
+// metrics in the Prometheus text format.
+type Metrics struct {
+	// DurationBuckets and SizeBuckets are the upper bounds of the buckets
+	// of the histograms. The defaults are used if nil.
+	// They must not be changed after recording.
+	DurationBuckets []float64
+	SizeBuckets     []float64
+
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	requests  map[metricLabels]uint64
+	durations map[metricLabels]*histogram
+	sizes     map[metricLabels]*histogram
+}
+
+type metricLabels struct {
+	method string
+	route  string
+	status int
+}
+
+func (l metricLabels) String() string {
+	return fmt.Sprintf(`method="%s",route="%s",status="%d"`,
+		escapeLabelValue(l.method), escapeLabelValue(l.route), l.status)
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics creates a new Metrics with the default buckets.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// metricMethod returns the method label of the method.
+// The methods not defined by RFC 9110 and RFC 5789 are "OTHER", so that the
+// clients cannot increase the series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// Middleware records the metrics of the requests to next.
+// The route label is the route pattern set by the mux, or "" if no route
+// matches, so that unknown paths do not increase the series. Likewise the
+// method label is "OTHER" for the unknown methods. Behind
+// App.Handler, it is available even if a middleware replaces the request.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := wrapResponseWriter(w)
+		m.inFlight.Add(1)
+
+		defer func() {
+			m.inFlight.Add(-1)
+			v := recover()
+			status := rw.Status()
+			if v != nil && !rw.wroteHeader {
+				status = http.StatusInternalServerError
+			}
+			m.observe(metricLabels{method: metricMethod(r.Method), route: patternOf(r), status: status}, time.Since(start), rw.size)
+			if v != nil {
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (m *Metrics) observe(l metricLabels, d time.Duration, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == nil {
+		m.requests = map[metricLabels]uint64{}
+		m.durations = map[metricLabels]*histogram{}
+		m.sizes = map[metricLabels]*histogram{}
+	}
+	m.requests[l]++
+
+	dh, ok := m.durations[l]
+	if !ok {
+		dh = newHistogram(m.DurationBuckets, DefaultDurationBuckets)
+		m.durations[l] = dh
+	}
+	dh.observe(d.Seconds())
+
+	sh, ok := m.sizes[l]
+	if !ok {
+		sh = newHistogram(m.SizeBuckets, DefaultSizeBuckets)
+		m.sizes[l] = sh
+	}
+	sh.observe(float64(size))
+}
+
+func newHistogram(bounds, def []float64) *histogram {
+	if bounds == nil {
+		bounds = def
+	}
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	m.mu.Lock()
+	labels := make([]metricLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	writeMetricHeader(cw, "http_requests_total", "counter", "Total number of HTTP requests.")
+	for _, l := range labels {
+		fmt.Fprintf(cw, "http_requests_total{%s} %d\n", l, m.requests[l])
+	}
+	writeMetricHeader(cw, "http_request_duration_seconds", "histogram", "Latency of HTTP requests in seconds.")
+	for _, l := range labels {
+		writeHistogram(cw, "http_request_duration_seconds", l.String(), m.durations[l])
+	}
+	writeMetricHeader(cw, "http_response_size_bytes", "histogram", "Size of HTTP responses in bytes.")
+	for _, l := range labels {
+		writeHistogram(cw, "http_response_size_bytes", l.String(), m.sizes[l])
+	}
+	m.mu.Unlock()
+
+	writeMetricHeader(cw, "http_requests_in_flight", "gauge", "Number of HTTP requests being served.")
+	fmt.Fprintf(cw, "http_requests_in_flight %d\n", m.inFlight.Load())
+
+	writeRuntimeMetrics(cw)
+
+	if err := cw.w.(*bufio.Writer).Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func writeRuntimeMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	writeMetricHeader(w, "go_info", "gauge", "Information about the Go environment.")
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", escapeLabelValue(runtime.Version()))
+	writeMetricHeader(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeMetricHeader(w, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", ms.Alloc)
+	writeMetricHeader(w, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", ms.Sys)
+	writeMetricHeader(w, "go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	fmt.Fprintf(w, "go_memstats_heap_objects %d\n", ms.HeapObjects)
+	writeMetricHeader(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", ms.NumGC)
+	writeMetricHeader(w, "go_gc_pause_seconds_total", "counter", "Total GC pause time in seconds.")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %s\n", formatFloat(float64(ms.PauseTotalNs)/1e9))
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// countingWriter counts the written bytes and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// metrics returns the metrics of the application.
+// It must be called with app.mu held.
+func (app *App) metrics() *Metrics {
+	if app.Metrics == nil {
+		app.Metrics = NewMetrics()
+	}
+	return app.Metrics
+}
+
+// metricsPath returns the path of the metrics endpoint on the server with
+// the name, or "" if the server does not serve it.
+func (app *App) metricsPath(name string) string {
+	c := app.config().Metrics
+	if !c.Enabled || c.Server != name {
+		return ""
+	}
+	if c.Path == "" {
+		return defaultMetricsPath
+	}
+	return c.Path
+}
+
+// withMetricsEndpoint returns a handler serving the metrics endpoint on the
+// server with the name and delegating the others to h.
+func (app *App) withMetricsEndpoint(name string, h http.Handler) http.Handler {
+	path := app.metricsPath(name)
+	if path == "" {
+		return h
+	}
+	app.mu.Lock()
+	m := app.metrics()
+	app.mu.Unlock()
+	if h == nil {
+		h = NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			setPattern(r, path)
+			m.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,194 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}", textHandler("user"))
+	rt.Get("/fail", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+	SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {}))
+
+	c := defaultConfig()
+	c.LivenessPath = "/healthz"
+	c.Metrics.Enabled = true
+	m := &Metrics{DurationBuckets: []float64{60}, SizeBuckets: []float64{1, 10}}
+	app := &App{Mux: rt, Config: c, Metrics: m}
+	h := app.Handler()
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail", "/posts/1", "/healthz"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	for _, method := range []string{"FOO1", "FOO2", "BAR"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/posts/1", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != metricsContentType {
+		t.Errorf("Content-Type = %v, want %v", got, metricsContentType)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{method="GET",route="",status="404"} 1` + "\n",
+		`http_requests_total{method="OTHER",route="",status="404"} 3` + "\n",
+		`http_requests_total{method="GET",route="/fail",status="500"} 1` + "\n",
+		`http_requests_total{method="GET",route="/healthz",status="200"} 1` + "\n",
+		`http_requests_total{method="GET",route="/users/{id}",status="200"} 2` + "\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="200",le="60"} 2` + "\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="200",le="+Inf"} 2` + "\n",
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="200"} 2` + "\n",
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="200",le="1"} 0` + "\n",
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="200",le="10"} 2` + "\n",
+		`http_response_size_bytes_sum{method="GET",route="/users/{id}",status="200"} 8` + "\n",
+		"# TYPE http_requests_in_flight gauge\nhttp_requests_in_flight 1\n",
+		"# TYPE go_goroutines gauge\n",
+		"go_info{version=",
+		"go_memstats_alloc_bytes ",
+		"go_gc_cycles_total ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+
+	// the scrape itself is recorded after the response
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `http_requests_total{method="GET",route="/metrics",status="200"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics do not contain %q", want)
+	}
+}
+
+func TestApp_Handler_route(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}", textHandler("user"))
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", textHandler("user"))
+
+	tests := []struct {
+		name    string
+		mux     http.Handler
+		pattern string
+	}{
+		{"router", rt, "/users/{id}"},
+		{"serve mux", mux, "GET /users/{id}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			exporter := &InMemoryExporter{}
+			c := defaultConfig()
+			c.Metrics.Enabled = true
+			c.Tracing.Enabled = true
+			c.AccessLog.Enabled = true
+			app := &App{
+				Mux:          tt.mux,
+				Config:       c,
+				AccessLogger: slog.New(slog.NewJSONHandler(&logs, nil)),
+				SpanExporter: exporter,
+			}
+			type key struct{}
+			app.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), key{}, "value")))
+				})
+			})
+			handler := app.Handler()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			if want := `http_requests_total{method="GET",route="` + tt.pattern + `",status="200"} 1`; !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("metrics do not contain %q", want)
+			}
+
+			var entry struct {
+				Route string `json:"route"`
+			}
+			if err := json.NewDecoder(&logs).Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.Route != tt.pattern {
+				t.Errorf("route of the access log = %v, want %v", entry.Route, tt.pattern)
+			}
+
+			spans := exporter.Spans()
+			if len(spans) == 0 {
+				t.Fatal("no spans are exported")
+			}
+			if got := spans[0].Attributes["http.route"]; got != tt.pattern {
+				t.Errorf("http.route of the span = %v, want %v", got, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestApp_withMetricsEndpoint(t *testing.T) {
+	c := defaultConfig()
+	c.Metrics.Enabled = true
+	c.Metrics.Path = "/internal/metrics"
+	c.Metrics.Server = "admin"
+	app := &App{Mux: textHandler("public"), Config: c}
+
+	tests := []struct {
+		name       string
+		server     string
+		handler    http.Handler
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"admin", "admin", textHandler("admin"), "/internal/metrics", http.StatusOK, "# HELP"},
+		{"admin others", "admin", textHandler("admin"), "/", http.StatusOK, "admin"},
+		{"admin only metrics", "admin", nil, "/", http.StatusNotFound, ""},
+		{"main", "", app.Handler(), "/internal/metrics", http.StatusOK, "public"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.withMetricsEndpoint(tt.server, tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if !strings.HasPrefix(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %.20q, want prefix %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if got, want := escapeLabelValue("a\\b\"c\nd"), `a\\b\"c\nd`; got != want {
+		t.Errorf("escapeLabelValue() = %v, want %v", got, want)
+	}
+}
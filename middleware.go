@@ -0,0 +1,103 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Middleware wraps a handler to add behavior before and after it.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is a list of middleware.
+// The first one is the outermost, that is, it sees the request first and
+// the response last.
+type Chain []Middleware
+
+// Then wraps the handler with the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+// Names returns the function names of the middleware for debugging.
+func (c Chain) Names() []string {
+	names := make([]string, len(c))
+	for i, mw := range c {
+		names[i] = funcName(mw)
+	}
+	return names
+}
+
+// String returns the names of the middleware from the outermost.
+func (c Chain) String() string {
+	return strings.Join(c.Names(), " -> ")
+}
+
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return "<nil>"
+	}
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return "<unknown>"
+	}
+	return fn.Name()
+}
+
+// Use appends the middleware to the main server of the application.
+// They run in the order of the calls, after the built-in ones such as the
+// panic recovery, and before the health endpoints and the mux.
+// It takes effect on the handlers created after the call.
+func (app *App) Use(mw ...Middleware) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.middleware = append(app.middleware, mw...)
+}
+
+// Chain returns the composed middleware of the main server,
+// including the built-in ones.
+func (app *App) Chain() Chain {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var c Chain
+	if h := app.config().RequestIDHeader; h != "" {
+		c = append(c, RequestID(h))
+	}
+	if app.config().Tracing.Enabled {
+		c = append(c, Trace(app.spanExporter()))
+	}
+	if app.config().Metrics.Enabled {
+		c = append(c, app.metrics().Middleware)
+	}
+	if app.config().AccessLog.Enabled {
+		c = append(c, AccessLog(app.accessLogger(), app.config()))
+	}
+	c = append(c, Recover)
+	if cors := &app.config().CORS; cors.Enabled() {
+		c = append(c, CORS(cors))
+	}
+	if app.RateLimiter != nil || app.config().RateLimit.Enabled() {
+		c = append(c, app.rateLimiter().Middleware)
+	}
+	return append(c, app.middleware...)
+}
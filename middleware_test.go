@@ -0,0 +1,113 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// traceMiddleware appends the name to X-Trace before and after next.
+func traceMiddleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", "before "+name)
+			next.ServeHTTP(w, r)
+			w.Header().Add("X-Trace", "after "+name)
+		})
+	}
+}
+
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestChain(t *testing.T) {
+	c := Chain{traceMiddleware("a"), traceMiddleware("b")}
+	h := c.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Trace", "handler")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	want := []string{"before a", "before b", "handler", "after b", "after a"}
+	if got := rec.Header()["X-Trace"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("X-Trace = %v, want %v", got, want)
+	}
+}
+
+func TestChain_Names(t *testing.T) {
+	c := Chain{Recover, noStore, traceMiddleware("a"), nil}
+	want := []string{
+		"github.com/drillbits/lambique.Recover",
+		"github.com/drillbits/lambique.noStore",
+		"github.com/drillbits/lambique.traceMiddleware.func1",
+		"<nil>",
+	}
+	if got := c.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+}
+
+func TestApp_Use(t *testing.T) {
+	rt := NewRouter()
+	rt.Use(traceMiddleware("router"))
+	rt.Get("/", textHandler("root"))
+	g := rt.Group("/admin", traceMiddleware("group"))
+	g.Use(noStore)
+	g.Get("/users", textHandler("users"))
+
+	c := defaultConfig()
+	c.LivenessPath = "/healthz"
+	app := &App{Mux: rt, Config: c}
+	app.Use(traceMiddleware("app1"), traceMiddleware("app2"))
+
+	if got, want := len(app.Chain()), 4; got != want {
+		t.Errorf("len(Chain()) = %v, want %v", got, want)
+	}
+	routes := rt.Routes()
+	if got, want := routes[1].Chain().Names()[2], "github.com/drillbits/lambique.noStore"; got != want {
+		t.Errorf("Chain().Names()[2] = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{"before app1", "before app2", "before router", "after router", "after app2", "after app1"}},
+		{"/admin/users", []string{"before app1", "before app2", "before router", "before group", "after group", "after router", "after app2", "after app1"}},
+		{"/healthz", []string{"before app1", "before app2", "after app2", "after app1"}},
+	}
+	h := app.Handler()
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %v, want %v", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header()["X-Trace"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("X-Trace = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// plainTextContentType is the content type set by http.Error.
+const plainTextContentType = "text/plain; charset=utf-8"
+
+// NotFoundHandler returns a handler writing a 404 ErrorResponse.
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := NotFound(fmt.Errorf("%s is not found", r.URL.Path))
+		NewErrorResponse(r, err, 0).Write(w, r)
+	})
+}
+
+// MethodNotAllowedHandler returns a handler writing a 405 ErrorResponse.
+// The Allow header should be set by the caller.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := WithStatus(fmt.Errorf("method %s is not allowed for %s", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+		NewErrorResponse(r, err, 0).Write(w, r)
+	})
+}
+
+// replaceErrorPages returns a handler replacing the plain text 404 and 405
+// responses of h, such as the ones of http.ServeMux, with the handlers.
+// The Allow header set by h is kept.
+// If h sets http.Request.Pattern like http.ServeMux and Router, only the
+// responses to the requests matching no route are replaced, so that the
+// handlers can still write their own responses with http.Error. Otherwise
+// all the plain text 404 and 405 responses are replaced.
+func replaceErrorPages(h, notFound, methodNotAllowed http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			// record the pattern set by the mux such as http.ServeMux
+			if info, ok := r.Context().Value(routeInfoKey{}).(*routeInfo); ok && info.pattern == "" {
+				info.pattern = r.Pattern
+			}
+		}()
+		h.ServeHTTP(&errorPageWriter{
+			ResponseWriter:   w,
+			r:                r,
+			notFound:         notFound,
+			methodNotAllowed: methodNotAllowed,
+		}, r)
+	})
+}
+
+// errorPageWriter replaces plain text error pages.
+type errorPageWriter struct {
+	http.ResponseWriter
+	r                *http.Request
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status < 200 {
+		// informational responses such as 103 Early Hints precede the
+		// final response
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	var replacement http.Handler
+	if w.r.Pattern == "" && w.Header().Get("Content-Type") == plainTextContentType {
+		switch status {
+		case http.StatusNotFound:
+			replacement = w.notFound
+		case http.StatusMethodNotAllowed:
+			replacement = w.methodNotAllowed
+		}
+	}
+	if replacement == nil {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.replaced = true
+	w.Header().Del("Content-Type")
+	w.Header().Del("X-Content-Type-Options")
+	replacement.ServeHTTP(w.ResponseWriter, w.r)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	// leave the underlying writer to write the header so that it can
+	// detect the content type
+	w.wroteHeader = true
+	if w.replaced {
+		// discard the plain text body
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *errorPageWriter) Flush() {
+	if w.replaced {
+		return
+	}
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (w *errorPageWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.wroteHeader = true
+	if w.replaced {
+		return io.Copy(io.Discard, src)
+	}
+	return readFrom(w.ResponseWriter, src)
+}
+
+// Push implements http.Pusher.
+func (w *errorPageWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,208 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApp_Handler_errorPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	})
+	mux.HandleFunc("GET /html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<p>custom</p>"))
+	})
+	mux.HandleFunc("GET /posts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such post", http.StatusNotFound)
+	})
+	rt := NewRouter()
+	rt.Get("/users/{id}", textHandler("user"))
+	gone := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	teapot := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name            string
+		app             *App
+		method          string
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantAllow       string
+		wantBody        string
+	}{
+		{
+			name:            "matched",
+			app:             &App{Mux: mux, Config: defaultConfig()},
+			method:          "GET",
+			path:            "/users/42",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "user",
+		},
+		{
+			name:            "not found",
+			app:             &App{Mux: mux, Config: defaultConfig()},
+			method:          "GET",
+			path:            "/posts",
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"about:blank","title":"Not Found","status":404,"detail":"/posts is not found","instance":"/posts","request_id":"req-1"}` + "\n",
+		},
+		{
+			name:            "method not allowed",
+			app:             &App{Mux: mux, Config: defaultConfig()},
+			method:          "DELETE",
+			path:            "/users/42",
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "application/problem+json",
+			wantAllow:       "GET, HEAD",
+			wantBody:        `{"type":"about:blank","title":"Method Not Allowed","status":405,"detail":"method DELETE is not allowed for /users/42","instance":"/users/42","request_id":"req-1"}` + "\n",
+		},
+		{
+			name:            "custom page",
+			app:             &App{Mux: mux, Config: defaultConfig()},
+			method:          "GET",
+			path:            "/html",
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "text/html",
+			wantBody:        "<p>custom</p>",
+		},
+		{
+			name:            "error of handler",
+			app:             &App{Mux: mux, Config: defaultConfig()},
+			method:          "GET",
+			path:            "/posts/42",
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "no such post\n",
+		},
+		{
+			name:       "custom handler",
+			app:        &App{Mux: mux, Config: defaultConfig(), NotFound: gone},
+			method:     "GET",
+			path:       "/posts",
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "custom handler of router",
+			app:        &App{Mux: rt, Config: defaultConfig(), NotFound: gone},
+			method:     "GET",
+			path:       "/posts",
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "custom method not allowed handler of router",
+			app:        &App{Mux: rt, Config: defaultConfig(), MethodNotAllowed: teapot},
+			method:     "DELETE",
+			path:       "/users/42",
+			wantStatus: http.StatusTeapot,
+			wantAllow:  "GET, HEAD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("X-Request-ID", "req-1")
+			tt.app.Handler().ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %v, want %v", got, tt.wantContentType)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %v, want %v", got, tt.wantAllow)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestApp_Handler_informational(t *testing.T) {
+	app := &App{
+		Mux: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		}),
+		Config: defaultConfig(),
+	}
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("status = %v, want %v", resp.StatusCode, http.StatusCreated)
+	}
+}
+
+func TestApp_Handler_hijack(t *testing.T) {
+	app := &App{
+		Mux: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(io.ReaderFrom); !ok {
+				t.Errorf("%T is not a io.ReaderFrom", w)
+			}
+			h, ok := w.(http.Hijacker)
+			if !ok {
+				t.Errorf("%T is not a http.Hijacker", w)
+				return
+			}
+			conn, rw, err := h.Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+			rw.Flush()
+		}),
+		Config: defaultConfig(),
+	}
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hijacked" {
+		t.Errorf("body = %v, want %v", string(body), "hijacked")
+	}
+}
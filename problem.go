@@ -0,0 +1,120 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ProblemType is a type of problems based on RFC7807.
+type ProblemType struct {
+	// URI identifies the problem type.
+	URI string
+	// Title is a short summary of the problem type.
+	// It should not change from occurrence to occurrence.
+	Title string
+	// Status is the default HTTP status code of the problem type.
+	Status int
+}
+
+// ProblemTyper is implemented by errors which declare their problem type.
+type ProblemTyper interface {
+	ProblemType() string
+}
+
+var (
+	problemTypesMu sync.RWMutex
+	problemTypes   = map[string]*ProblemType{}
+)
+
+// RegisterProblemType registers the problem type.
+// It panics if the URI is empty or already registered.
+func RegisterProblemType(pt *ProblemType) *ProblemType {
+	if pt.URI == "" {
+		panic("lambique: RegisterProblemType with empty URI")
+	}
+
+	problemTypesMu.Lock()
+	defer problemTypesMu.Unlock()
+
+	if _, dup := problemTypes[pt.URI]; dup {
+		panic("lambique: RegisterProblemType called twice for " + pt.URI)
+	}
+	problemTypes[pt.URI] = pt
+	return pt
+}
+
+// LookupProblemType returns the problem type registered with the URI.
+func LookupProblemType(uri string) (*ProblemType, bool) {
+	problemTypesMu.RLock()
+	defer problemTypesMu.RUnlock()
+
+	pt, ok := problemTypes[uri]
+	return pt, ok
+}
+
+// Errorf formats an error of the problem type.
+func (pt *ProblemType) Errorf(format string, a ...interface{}) error {
+	return &ProblemError{
+		Type: pt.URI,
+		Err:  fmt.Errorf(format, a...),
+	}
+}
+
+// ProblemError is an error with a problem type.
+type ProblemError struct {
+	Type string
+	Err  error
+}
+
+// WithProblemType annotates err with the problem type.
+func WithProblemType(err error, uri string) error {
+	if err == nil {
+		return nil
+	}
+	return &ProblemError{
+		Type: uri,
+		Err:  err,
+	}
+}
+
+func (e *ProblemError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ProblemError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemType returns the URI of the problem type.
+func (e *ProblemError) ProblemType() string {
+	return e.Type
+}
+
+// problemTypeOf returns the problem type declared by err or the errors it wraps.
+func problemTypeOf(err error) (*ProblemType, bool) {
+	var typer ProblemTyper
+	if !errors.As(err, &typer) {
+		return nil, false
+	}
+	uri := typer.ProblemType()
+	if pt, ok := LookupProblemType(uri); ok {
+		return pt, true
+	}
+	return &ProblemType{URI: uri}, true
+}
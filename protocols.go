@@ -0,0 +1,72 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+var defaultAltSvcMaxAge = 24 * 60 * 60 // seconds
+
+// HTTP3Server is a HTTP/3 server such as http3.Server of quic-go.
+type HTTP3Server interface {
+	Serve(conn net.PacketConn) error
+	Close() error
+}
+
+// NewHTTP3ServerFunc creates a HTTP/3 server for the handler and TLS config.
+// See App.NewHTTP3Server.
+type NewHTTP3ServerFunc func(handler http.Handler, tc *tls.Config) HTTP3Server
+
+// setProtocols sets the protocols served by s.
+func setProtocols(s *http.Server, sc *ServerConfig) {
+	if !sc.H2C {
+		return
+	}
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	s.Protocols = p
+}
+
+// listenHTTP3 listens on the UDP address of addr and creates a HTTP/3 server
+// serving the handler of s.
+// The handler of s is replaced to advertise HTTP/3 with Alt-Svc header.
+func (app *App) listenHTTP3(addr string, s *http.Server) (net.PacketConn, HTTP3Server, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h3 := app.NewHTTP3Server(s.Handler, s.TLSConfig)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	s.Handler = altSvcHandler(s.Handler, fmt.Sprintf(`h3=":%d"; ma=%d`, port, defaultAltSvcMaxAge))
+
+	return conn, h3, nil
+}
+
+// altSvcHandler advertises the alternative service to the clients.
+func altSvcHandler(h http.Handler, altSvc string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,236 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApp_Serve_h2c(t *testing.T) {
+	tests := []struct {
+		name      string
+		h2c       bool
+		wantProto string
+	}{
+		{
+			name:      "enabled",
+			h2c:       true,
+			wantProto: "HTTP/2.0",
+		},
+		{
+			name:      "disabled",
+			h2c:       false,
+			wantProto: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.H2C = tt.h2c
+			app := &App{Mux: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}), Config: c}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go app.Serve(ln)
+			defer app.Shutdown(context.Background())
+
+			p := new(http.Protocols)
+			p.SetUnencryptedHTTP2(true)
+			client := &http.Client{Transport: &http.Transport{Protocols: p}}
+
+			resp, err := client.Get("http://" + ln.Addr().String() + "/")
+			if tt.wantProto == "" {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("h2c request succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != tt.wantProto {
+				t.Errorf("protocol = %v, want %v", string(body), tt.wantProto)
+			}
+		})
+	}
+}
+
+type fakeHTTP3Server struct {
+	handler http.Handler
+	served  chan net.PacketConn
+	closed  chan struct{}
+}
+
+func (s *fakeHTTP3Server) Serve(conn net.PacketConn) error {
+	s.served <- conn
+	<-s.closed
+	return nil
+}
+
+func (s *fakeHTTP3Server) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestApp_Serve_http3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+
+	c := defaultConfig()
+	c.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	h3 := &fakeHTTP3Server{
+		served: make(chan net.PacketConn, 1),
+		closed: make(chan struct{}),
+	}
+	app := &App{
+		Mux:    textHandler("ok"),
+		Config: c,
+		NewHTTP3Server: func(handler http.Handler, tc *tls.Config) HTTP3Server {
+			h3.handler = handler
+			return h3
+		},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Serve(ln)
+
+	conn := <-h3.served
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	if port != ln.Addr().(*net.TCPAddr).Port {
+		t.Errorf("HTTP/3 port = %v, want %v", port, ln.Addr().(*net.TCPAddr).Port)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+	if got := resp.Header.Get("Alt-Svc"); got != want {
+		t.Errorf("Alt-Svc = %v, want %v", got, want)
+	}
+
+	app.Shutdown(context.Background())
+	select {
+	case <-h3.closed:
+	default:
+		t.Errorf("HTTP/3 server is not closed on shutdown")
+	}
+}
+
+func TestApp_Serve_http3WithoutTLS(t *testing.T) {
+	app := &App{
+		Mux:    textHandler("ok"),
+		Config: defaultConfig(),
+		NewHTTP3Server: func(handler http.Handler, tc *tls.Config) HTTP3Server {
+			t.Errorf("HTTP/3 server is created without TLS")
+			return &fakeHTTP3Server{}
+		},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Serve(ln)
+	defer app.Shutdown(context.Background())
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Alt-Svc"); got != "" {
+		t.Errorf("Alt-Svc = %v, want none", got)
+	}
+}
+
+func TestApp_Start_http3FailFast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+	notSocket := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(notSocket, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// find a free port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c := defaultConfig()
+	c.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	c.Servers = map[string]*ServerConfig{
+		"admin": {Addr: "unix:" + notSocket},
+	}
+	app := &App{
+		Mux:    textHandler("ok"),
+		Config: c,
+		NewHTTP3Server: func(handler http.Handler, tc *tls.Config) HTTP3Server {
+			return &fakeHTTP3Server{}
+		},
+	}
+	app.Handle("admin", textHandler("admin"))
+
+	err = app.Start(addr)
+	if err == nil {
+		t.Fatalf("App.Start() succeeded, want error")
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("UDP port of the main server is not closed: %v", err)
+	}
+	conn.Close()
+}
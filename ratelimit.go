@@ -0,0 +1,373 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitSweepInterval is how often the in-memory store drops full
+	// buckets.
+	rateLimitSweepInterval = time.Minute
+
+	defaultRateLimitMaxBuckets = 100000
+)
+
+// RateLimitRule is a limit of the requests by a client.
+type RateLimitRule struct {
+	// Limit is the number of requests allowed in Period.
+	// A zero or negative limit means no limit.
+	Limit int `toml:"limit"`
+
+	// Period is the period of Limit. The default is a second.
+	Period Duration `toml:"period"`
+
+	// Burst is the max number of requests allowed at once.
+	// The default is Limit.
+	Burst int `toml:"burst"`
+
+	// Key identifies the client. "ip" is the client IP resolved with
+	// Config.TrustedProxies, and "header:X-API-Key" is the value of the
+	// header falling back to the client IP. The default is "ip".
+	// The values of the header are used only if RateLimiter.ValidateKey
+	// accepts them, since a client can evade the limit by making up values.
+	// It is ignored if RateLimiter.Key is set.
+	Key string `toml:"key"`
+}
+
+// rate returns the tokens added per second and the size of the bucket.
+func (rule *RateLimitRule) rate() (float64, int) {
+	period := rule.Period.orDefault(time.Second)
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = rule.Limit
+	}
+	return float64(rule.Limit) / period.Seconds(), burst
+}
+
+// RateLimitConfig is a config for the rate limiting.
+type RateLimitConfig struct {
+	// RateLimitRule is the rule of the routes not in Routes.
+	RateLimitRule
+
+	// Routes are the rules by the route pattern as in http.Request.Pattern.
+	// A rule replaces the default one, so that a route can be exempted
+	// with a zero limit.
+	Routes map[string]*RateLimitRule `toml:"routes"`
+}
+
+// Enabled reports whether any of the rules limits the requests.
+func (c *RateLimitConfig) Enabled() bool {
+	if c.Limit > 0 {
+		return true
+	}
+	for _, rule := range c.Routes {
+		if rule.Limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitResult is the result of taking a token from a bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is how long it takes to fill the bucket.
+	Reset time.Duration
+
+	// RetryAfter is how long it takes to have a token if not allowed.
+	RetryAfter time.Duration
+}
+
+// TokenBucket is the state of a token bucket.
+// Stores keep it for each key.
+type TokenBucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// Take takes a token from the bucket refilled at rate tokens per second up
+// to burst tokens.
+func (b *TokenBucket) Take(now time.Time, rate float64, burst int) RateLimitResult {
+	switch {
+	case b.Last.IsZero():
+		b.Tokens = float64(burst)
+		b.Last = now
+	case now.After(b.Last):
+		b.Tokens = math.Min(float64(burst), b.Tokens+now.Sub(b.Last).Seconds()*rate)
+		b.Last = now
+	}
+
+	res := RateLimitResult{Limit: burst}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - b.Tokens) / rate)
+	}
+	res.Remaining = int(b.Tokens)
+	res.Reset = secondsToDuration((float64(burst) - b.Tokens) / rate)
+	return res
+}
+
+// full reports whether the bucket is full at now.
+func (b *TokenBucket) full(now time.Time, rate float64, burst int) bool {
+	return b.Tokens+now.Sub(b.Last).Seconds()*rate >= float64(burst)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// RateLimitStore keeps the token buckets.
+// A store shared by the instances makes the limits global.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key.
+	// See TokenBucket.Take.
+	Take(ctx context.Context, key string, rate float64, burst int) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore is a RateLimitStore in memory.
+// The zero value is ready to use.
+type MemoryRateLimitStore struct {
+	// Now returns the current time. time.Now is used if nil.
+	Now func() time.Time
+
+	// MaxBuckets is the max number of the buckets. The least recently used
+	// bucket is dropped for a new one beyond it, which resets the limit of
+	// the client. The default is 100000.
+	MaxBuckets int
+
+	mu        sync.Mutex
+	buckets   map[string]*list.Element // of *memoryBucket
+	lru       list.List                // the most recently used first
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	TokenBucket
+	key   string
+	rate  float64
+	burst int
+}
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{}
+}
+
+// Take takes a token from the bucket of the key.
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (RateLimitResult, error) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = map[string]*list.Element{}
+		s.lastSweep = now
+	}
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		// full buckets are the same as the new ones
+		for k, e := range s.buckets {
+			if b := e.Value.(*memoryBucket); b.full(now, b.rate, b.burst) {
+				s.lru.Remove(e)
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.buckets[key]
+	if ok {
+		s.lru.MoveToFront(e)
+	} else {
+		max := s.MaxBuckets
+		if max <= 0 {
+			max = defaultRateLimitMaxBuckets
+		}
+		if len(s.buckets) >= max {
+			// the least recently used bucket is most likely full
+			oldest := s.lru.Remove(s.lru.Back()).(*memoryBucket)
+			delete(s.buckets, oldest.key)
+		}
+		e = s.lru.PushFront(&memoryBucket{key: key})
+		s.buckets[key] = e
+	}
+	b := e.Value.(*memoryBucket)
+	b.rate, b.burst = rate, burst
+	return b.Take(now, rate, burst), nil
+}
+
+// Len returns the number of the buckets.
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// RateLimitKeyFunc returns the key of the client of the request.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimiter limits the requests by the clients with token buckets.
+type RateLimiter struct {
+	// Config is the rules. Config.RateLimit of the application is used if nil.
+	Config *RateLimitConfig
+
+	// Store keeps the buckets. A MemoryRateLimitStore is used if nil.
+	Store RateLimitStore
+
+	// Key returns the key of the client, overriding the key of the rules.
+	Key RateLimitKeyFunc
+
+	// ValidateKey reports whether the value of the header of a "header:"
+	// key identifies a client, such as a known API key. The client IP is
+	// used instead of the invalid values, and of all the values if nil.
+	ValidateKey func(r *http.Request, value string) bool
+
+	// TrustedProxies are used to resolve the client IP. See ClientIP.
+	TrustedProxies []IPNet
+
+	// Pattern returns the route pattern of the request before it is routed.
+	// http.Request.Pattern is used if nil.
+	Pattern func(r *http.Request) string
+}
+
+// Middleware limits the requests to next.
+// It sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and answers the requests over the limit with a 429
+// ErrorResponse and the Retry-After header.
+// The requests are allowed if the store fails.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	store := l.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	c := l.Config
+	if c == nil {
+		c = &RateLimitConfig{}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.Pattern
+		if l.Pattern != nil {
+			pattern = l.Pattern(r)
+		}
+		rule, ok := c.Routes[pattern]
+		if !ok || pattern == "" {
+			rule = &c.RateLimitRule
+		}
+		if rule.Limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var client string
+		if l.Key != nil {
+			client = l.Key(r)
+		} else {
+			client = l.clientKey(rule.Key, r)
+		}
+		key := client
+		if rule != &c.RateLimitRule {
+			key = pattern + " " + client
+		}
+
+		rate, burst := rule.rate()
+		res, err := store.Take(r.Context(), key, rate, burst)
+		if err != nil {
+			log.Printf("lambique: rate limit store: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			retryAfter := ceilSeconds(res.RetryAfter)
+			h.Set("Retry-After", strconv.Itoa(retryAfter))
+			err := RateLimited(fmt.Errorf("rate limit exceeded; retry after %d seconds", retryAfter))
+			NewErrorResponse(r, err, 0).Write(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) clientKey(key string, r *http.Request) string {
+	if name, ok := strings.CutPrefix(key, "header:"); ok && l.ValidateKey != nil {
+		if v := r.Header.Get(name); v != "" && l.ValidateKey(r, v) {
+			return key + ":" + v
+		}
+	}
+	return "ip:" + ClientIP(r, l.TrustedProxies)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimiter returns the rate limiter of the application.
+// It must be called with app.mu held.
+func (app *App) rateLimiter() *RateLimiter {
+	if app.RateLimiter == nil {
+		app.RateLimiter = &RateLimiter{}
+	}
+	l := app.RateLimiter
+	if l.Store == nil {
+		// share the buckets among the handlers of the application
+		l.Store = NewMemoryRateLimitStore()
+	}
+	if l.Config == nil {
+		l.Config = &app.config().RateLimit
+	}
+	if l.TrustedProxies == nil {
+		l.TrustedProxies = app.config().TrustedProxies
+	}
+	if l.Pattern == nil {
+		m, ok := app.mux().(interface {
+			Handler(*http.Request) (http.Handler, string)
+		})
+		l.Pattern = func(r *http.Request) string {
+			if pattern := app.endpointPattern(r); pattern != "" {
+				return pattern
+			}
+			if !ok {
+				return r.Pattern
+			}
+			_, pattern := m.Handler(r)
+			return pattern
+		}
+	}
+	return l
+}
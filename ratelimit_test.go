@@ -0,0 +1,285 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	start := time.Unix(0, 0)
+	var b TokenBucket
+	// 2 tokens per second up to 3
+	steps := []struct {
+		after time.Duration
+		want  RateLimitResult
+	}{
+		{0, RateLimitResult{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+		{0, RateLimitResult{Allowed: true, Limit: 3, Remaining: 1, Reset: time.Second}},
+		{0, RateLimitResult{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{0, RateLimitResult{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{250 * time.Millisecond, RateLimitResult{Allowed: false, Limit: 3, Remaining: 0, Reset: 1250 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{500 * time.Millisecond, RateLimitResult{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		// the clock of another instance is behind
+		{400 * time.Millisecond, RateLimitResult{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{10 * time.Second, RateLimitResult{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+	}
+	for i, s := range steps {
+		if got := b.Take(start.Add(s.after), 2, 3); got != s.want {
+			t.Errorf("%d: Take() = %+v, want %+v", i, got, s.want)
+		}
+	}
+}
+
+// fakeRateLimitStore is a shared store counting the requests by key.
+type fakeRateLimitStore struct {
+	mu    sync.Mutex
+	err   error
+	count map[string]int
+}
+
+func (s *fakeRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return RateLimitResult{}, s.err
+	}
+	if s.count == nil {
+		s.count = map[string]int{}
+	}
+	s.count[key]++
+	remaining := burst - s.count[key]
+	if remaining < 0 {
+		return RateLimitResult{Limit: burst, Reset: time.Minute, RetryAfter: 1500 * time.Millisecond}, nil
+	}
+	return RateLimitResult{Allowed: true, Limit: burst, Remaining: remaining, Reset: time.Minute}, nil
+}
+
+func TestApp_rateLimit(t *testing.T) {
+	var c Config
+	_, err := toml.Decode(`
+trusted_proxies = ["10.0.0.0/8"]
+liveness_path = "/healthz"
+
+[rate_limit]
+limit = 2
+period = "1m"
+
+[rate_limit.routes."/search"]
+limit = 1
+key = "header:X-API-Key"
+
+[rate_limit.routes."/status"]
+limit = 0
+
+[rate_limit.routes."/healthz"]
+limit = 0
+`, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt := NewRouter()
+	rt.Get("/users/{id}", textHandler("user"))
+	rt.Get("/search", textHandler("search"))
+	rt.Get("/status", textHandler("status"))
+
+	store := &fakeRateLimitStore{}
+	l := &RateLimiter{
+		Store: store,
+		ValidateKey: func(r *http.Request, value string) bool {
+			return strings.HasPrefix(value, "key-")
+		},
+	}
+	app := &App{Mux: rt, Config: &c, RateLimiter: l}
+	h := app.Handler()
+
+	type request struct {
+		path     string
+		clientIP string
+		apiKey   string
+	}
+	requests := []struct {
+		request
+		wantStatus    int
+		wantRemaining string
+	}{
+		{request{"/users/1", "198.51.100.1", ""}, http.StatusOK, "1"},
+		{request{"/users/2", "198.51.100.1", ""}, http.StatusOK, "0"},
+		{request{"/users/3", "198.51.100.1", ""}, http.StatusTooManyRequests, "0"},
+		{request{"/users/3", "198.51.100.2", ""}, http.StatusOK, "1"},
+		{request{"/search", "198.51.100.1", "key-1"}, http.StatusOK, "0"},
+		{request{"/search", "198.51.100.2", "key-1"}, http.StatusTooManyRequests, "0"},
+		{request{"/search", "198.51.100.2", "key-2"}, http.StatusOK, "0"},
+		{request{"/search", "198.51.100.2", "forged"}, http.StatusOK, "0"},
+		{request{"/search", "198.51.100.2", "made-up"}, http.StatusTooManyRequests, "0"},
+		{request{"/healthz", "198.51.100.1", ""}, http.StatusOK, ""},
+		{request{"/status", "198.51.100.1", ""}, http.StatusOK, ""},
+		{request{"/status", "198.51.100.1", ""}, http.StatusOK, ""},
+	}
+	for i, req := range requests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", req.path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", req.clientIP)
+		if req.apiKey != "" {
+			r.Header.Set("X-API-Key", req.apiKey)
+		}
+		h.ServeHTTP(rec, r)
+
+		if rec.Code != req.wantStatus {
+			t.Errorf("%d: status = %v, want %v", i, rec.Code, req.wantStatus)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != req.wantRemaining {
+			t.Errorf("%d: RateLimit-Remaining = %v, want %v", i, got, req.wantRemaining)
+		}
+		if req.wantStatus != http.StatusTooManyRequests {
+			continue
+		}
+		if got := rec.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%d: Retry-After = %v, want %v", i, got, "2")
+		}
+		if got := rec.Header().Get("RateLimit-Reset"); got != "60" {
+			t.Errorf("%d: RateLimit-Reset = %v, want %v", i, got, "60")
+		}
+		var resp ErrorResponse
+		if err := resp.UnmarshalJSON(rec.Body.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusTooManyRequests || resp.Title != "Too Many Requests" {
+			t.Errorf("%d: response = %+v", i, resp)
+		}
+	}
+
+	wantKeys := []string{"ip:198.51.100.1", "ip:198.51.100.2", "/search header:X-API-Key:key-1", "/search header:X-API-Key:key-2", "/search ip:198.51.100.2"}
+	for _, key := range wantKeys {
+		if store.count[key] == 0 {
+			t.Errorf("no bucket for %s in %v", key, store.count)
+		}
+	}
+	if len(store.count) != len(wantKeys) {
+		t.Errorf("buckets = %v, want %v", store.count, wantKeys)
+	}
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	config := &RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1}}
+
+	t.Run("custom key", func(t *testing.T) {
+		l := &RateLimiter{
+			Config: config,
+			Key:    func(r *http.Request) string { return "everyone" },
+		}
+		h := l.Middleware(textHandler("ok"))
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = []string{"192.0.2.1:1", "192.0.2.2:1"}[i]
+			h.ServeHTTP(rec, r)
+			if rec.Code != want {
+				t.Errorf("%d: status = %v, want %v", i, rec.Code, want)
+			}
+		}
+	})
+
+	t.Run("header key without validation", func(t *testing.T) {
+		l := &RateLimiter{
+			Config: &RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1, Key: "header:X-API-Key"}},
+		}
+		h := l.Middleware(textHandler("ok"))
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-API-Key", []string{"made-up-1", "made-up-2"}[i])
+			h.ServeHTTP(rec, r)
+			if rec.Code != want {
+				t.Errorf("%d: status = %v, want %v", i, rec.Code, want)
+			}
+		}
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		l := &RateLimiter{
+			Config: config,
+			Store:  &fakeRateLimitStore{err: errors.New("connection refused")},
+		}
+		rec := httptest.NewRecorder()
+		l.Middleware(textHandler("ok")).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", rec.Code, http.StatusOK)
+		}
+		if _, ok := rec.Header()["Ratelimit-Limit"]; ok {
+			t.Error("RateLimit-Limit is set")
+		}
+	})
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := &MemoryRateLimitStore{Now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false} {
+		res, err := s.Take(ctx, "a", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != want {
+			t.Errorf("%d: Allowed = %v, want %v", i, res.Allowed, want)
+		}
+	}
+	s.Take(ctx, "b", 0.01, 2)
+
+	// "a" is full again, "b" is not
+	now = now.Add(rateLimitSweepInterval)
+	s.Take(ctx, "c", 1, 1)
+	if got := s.Len(); got != 2 {
+		t.Errorf("Len() = %v, want %v", got, 2)
+	}
+
+	// the least recently used bucket is dropped
+	s = &MemoryRateLimitStore{Now: func() time.Time { return now }, MaxBuckets: 2}
+	for i, tt := range []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false},
+		{"c", true}, // drops "b"
+		{"a", false},
+		{"b", true},
+	} {
+		res, err := s.Take(ctx, tt.key, 0.01, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != tt.want {
+			t.Errorf("%d: Take(%s).Allowed = %v, want %v", i, tt.key, res.Allowed, tt.want)
+		}
+	}
+	if got := s.Len(); got != 2 {
+		t.Errorf("Len() with MaxBuckets = %v, want %v", got, 2)
+	}
+}
@@ -0,0 +1,148 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var reported error
+	var reportedStack []byte
+	SetErrorReporter(ErrorReporterFunc(func(r *http.Request, id string, err error, stack []byte) {
+		reported, reportedStack = err, stack
+	}))
+	defer SetErrorReporter(ErrorReporterFunc(logError))
+
+	t.Run("before response", func(t *testing.T) {
+		reported = nil
+		h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var m map[string]int
+			m["nil"]++
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %v, want %v", rec.Code, http.StatusInternalServerError)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+			t.Errorf("Content-Type = %v, want %v", got, "application/problem+json")
+		}
+		resp := &ErrorResponse{}
+		err := json.NewDecoder(rec.Body).Decode(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusInternalServerError {
+			t.Errorf("body status = %v, want %v", resp.Status, http.StatusInternalServerError)
+		}
+		if _, ok := reported.(*PanicError); !ok {
+			t.Errorf("reported error = %#v, want *PanicError", reported)
+		}
+		if !strings.Contains(string(reportedStack), "recover_test.go") {
+			t.Errorf("reported stack does not contain the panicking handler:\n%s", reportedStack)
+		}
+	})
+
+	t.Run("after response", func(t *testing.T) {
+		reported = nil
+		h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("boom")
+		}))
+		rec := httptest.NewRecorder()
+
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want %v", v, http.ErrAbortHandler)
+			}
+			if rec.Body.String() != "partial" {
+				t.Errorf("body = %v, want %v", rec.Body.String(), "partial")
+			}
+			if reported == nil {
+				t.Errorf("error is not reported")
+			}
+		}()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	})
+
+	t.Run("abort handler", func(t *testing.T) {
+		reported = nil
+		h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want %v", v, http.ErrAbortHandler)
+			}
+			if reported != nil {
+				t.Errorf("reported %v, want nothing", reported)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
+
+func TestRecover_responseWriter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Errorf("%T is not a http.Hijacker", w)
+			return
+		}
+		conn, rw, err := h.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})
+	mux.HandleFunc("/read-from", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Errorf("%T is not a io.ReaderFrom", w)
+		}
+		io.Copy(w, strings.NewReader("copied"))
+	})
+	ts := httptest.NewServer(Recover(mux))
+	defer ts.Close()
+
+	for path, want := range map[string]string{
+		"/hijack":    "hijacked",
+		"/read-from": "copied",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want {
+			t.Errorf("%s: body = %v, want %v", path, string(body), want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// internalErrorDetail is the detail of server errors shown to the clients.
+const internalErrorDetail = "The server encountered an internal error. Please contact us with the correlation ID."
+
+// ErrorReporter reports server errors hidden from the clients.
+type ErrorReporter interface {
+	// ReportError reports err occurred while serving r.
+	// id is the correlation ID shown to the client.
+	ReportError(r *http.Request, id string, err error, stack []byte)
+}
+
+// ErrorReporterFunc is an adapter to use ordinary functions as ErrorReporter.
+type ErrorReporterFunc func(r *http.Request, id string, err error, stack []byte)
+
+// ReportError calls f(r, id, err, stack).
+func (f ErrorReporterFunc) ReportError(r *http.Request, id string, err error, stack []byte) {
+	f(r, id, err, stack)
+}
+
+var (
+	errorReporterMu sync.RWMutex
+	errorReporter   ErrorReporter = ErrorReporterFunc(logError)
+)
+
+// SetErrorReporter sets the reporter of server errors.
+// The errors are logged by the standard logger by default.
+func SetErrorReporter(reporter ErrorReporter) {
+	errorReporterMu.Lock()
+	defer errorReporterMu.Unlock()
+	errorReporter = reporter
+}
+
+func reportError(r *http.Request, id string, err error, stack []byte) {
+	errorReporterMu.RLock()
+	reporter := errorReporter
+	errorReporterMu.RUnlock()
+
+	if reporter != nil {
+		reporter.ReportError(r, id, err, stack)
+	}
+}
+
+func logError(r *http.Request, id string, err error, stack []byte) {
+	log.Printf("lambique: %s %s %s: %v\n%s", id, r.Method, r.RequestURI, err, stack)
+}
+
+// newCorrelationID generates a random ID to correlate a response with the report.
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
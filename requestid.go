@@ -0,0 +1,102 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"net/http"
+)
+
+// maxRequestIDLength is the max length of an incoming request ID.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx with the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// GetRequestID returns the request ID in ctx, or "" if there is none.
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID returns a middleware giving each request an ID.
+// A valid ID in the header of the request is used, otherwise a new one is
+// generated. The ID is stored in the context and set to the header of the
+// response.
+func RequestID(header string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !validRequestID(id) {
+				id = newCorrelationID()
+			}
+			w.Header().Set(header, id)
+			next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+// validRequestID reports whether id is safe to log and to echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDTransport is an http.RoundTripper propagating the request ID in
+// the context of outgoing requests.
+type RequestIDTransport struct {
+	// Header is the header of the ID. The default is "X-Request-ID".
+	Header string
+
+	// Base is the underlying transport.
+	// http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip sets the request ID to the header unless it is already set.
+func (t *RequestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = defaultRequestIDHeader
+	}
+
+	id := GetRequestID(r.Context())
+	if id == "" || r.Header.Get(header) != "" {
+		return base.RoundTrip(r)
+	}
+	// a RoundTripper must not modify the request
+	r = r.Clone(r.Context())
+	r.Header.Set(header, id)
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,111 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{"valid", "3f2c1a9e-7b4d-4f6a-9c1e-2d5b8a7f6e10", true},
+		{"generated", "", false},
+		{"invalid characters", "abc\r\nSet-Cookie: x=y", false},
+		{"spaces", "a b", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RequestID("X-Request-ID")(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				got = GetRequestID(r.Context())
+				return NotFound(nil)
+			}))
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set("X-Request-ID", tt.incoming)
+			}
+			h.ServeHTTP(rec, r)
+
+			if tt.wantSame && got != tt.incoming {
+				t.Errorf("GetRequestID() = %v, want %v", got, tt.incoming)
+			}
+			if !tt.wantSame && (got == tt.incoming || !validRequestID(got)) {
+				t.Errorf("GetRequestID() = %v, want a generated ID", got)
+			}
+			if h := rec.Header().Get("X-Request-ID"); h != got {
+				t.Errorf("X-Request-ID = %v, want %v", h, got)
+			}
+			var resp ErrorResponse
+			if err := resp.UnmarshalJSON(rec.Body.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Extensions["request_id"] != got {
+				t.Errorf("request_id = %v, want %v", resp.Extensions["request_id"], got)
+			}
+		})
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Request-ID"))
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &RequestIDTransport{}}
+	ctx := WithRequestID(context.Background(), "abc")
+
+	for _, set := range []string{"", "explicit"} {
+		r, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if set != "" {
+			r.Header.Set("X-Request-ID", set)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if set == "" && r.Header.Get("X-Request-ID") != "" {
+			t.Error("RoundTrip() modifies the request")
+		}
+	}
+
+	r, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{"abc", "explicit", ""}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("X-Request-ID = %q, want %q", got, want)
+	}
+}
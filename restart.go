@@ -0,0 +1,226 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Environment variables passed to the new process on restart.
+const (
+	// envListenAddrs is the comma separated addresses of the passed listeners.
+	// The listeners are passed as the file descriptors from 3 in the same order.
+	envListenAddrs = "LAMBIQUE_LISTEN_ADDRS"
+	// envReadyFD is the file descriptor to notify the parent process of readiness.
+	envReadyFD = "LAMBIQUE_READY_FD"
+)
+
+var (
+	inheritedOnce sync.Once
+	inheritedMu   sync.Mutex
+	inherited     map[string]uintptr
+
+	readyOnce sync.Once
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Restart starts a new process of the same binary with the listeners of
+// the application, waits for it to be ready and then shuts down the
+// application gracefully.
+// The new process takes over the listeners by calling App.Start with the same addresses.
+func (app *App) Restart(ctx context.Context) error {
+	app.mu.Lock()
+	running := append([]*runningServer(nil), app.running...)
+	app.mu.Unlock()
+
+	if len(running) == 0 {
+		return errors.New("lambique: no running servers to restart")
+	}
+
+	var addrs []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, rs := range running {
+		if rs.http3 != nil {
+			return fmt.Errorf("lambique: HTTP/3 on %s cannot be passed", rs.addr)
+		}
+		fl, ok := rs.listener.(filer)
+		if !ok {
+			return fmt.Errorf("lambique: listener on %s cannot be passed", rs.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, rs.addr)
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(environWithout(envListenAddrs, envReadyFD),
+		envListenAddrs+"="+strings.Join(addrs, ","),
+		fmt.Sprintf("%s=%d", envReadyFD, listenFDsStart+len(files)),
+	)
+	cmd.ExtraFiles = append(files, w)
+
+	// the socket files are taken over by the new process
+	setUnlinkOnClose(running, false)
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		setUnlinkOnClose(running, true)
+		return err
+	}
+	go cmd.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		// EOF if the new process exits without notifying
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			setUnlinkOnClose(running, true)
+			return fmt.Errorf("lambique: new process exited before ready: %v", err)
+		}
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		setUnlinkOnClose(running, true)
+		return ctx.Err()
+	}
+
+	return app.Shutdown(ctx)
+}
+
+// RestartOnSignal restarts the application when one of sigs is received.
+// See App.Restart.
+func (app *App) RestartOnSignal(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for range ch {
+			ctx, cancel := context.WithTimeout(context.Background(), app.config().ShutdownTimeout.orDefault(defaultShutdownTimeout))
+			err := app.Restart(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("lambique: restart failed: %v", err)
+				continue
+			}
+			signal.Stop(ch)
+			return
+		}
+	}()
+}
+
+func setUnlinkOnClose(running []*runningServer, unlink bool) {
+	for _, rs := range running {
+		if ul, ok := rs.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(unlink)
+		}
+	}
+}
+
+func environWithout(keys ...string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		keep := true
+		for _, key := range keys {
+			if strings.HasPrefix(kv, key+"=") {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// inheritedListener returns the listener on addr passed from the parent process.
+// It reports false if no listener is passed for addr.
+func inheritedListener(addr string) (net.Listener, bool, error) {
+	inheritedOnce.Do(func() {
+		inherited = map[string]uintptr{}
+		s := os.Getenv(envListenAddrs)
+		if s == "" {
+			return
+		}
+		for i, a := range strings.Split(s, ",") {
+			inherited[a] = uintptr(listenFDsStart + i)
+		}
+		os.Unsetenv(envListenAddrs)
+	})
+
+	inheritedMu.Lock()
+	fd, ok := inherited[addr]
+	delete(inherited, addr)
+	inheritedMu.Unlock()
+
+	if !ok {
+		return nil, false, nil
+	}
+	ln, err := fileListener(fd, addr)
+	return ln, true, err
+}
+
+// notifyReady notifies the parent process that the new process is ready to serve.
+func notifyReady() {
+	readyOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+		if err != nil {
+			return
+		}
+		os.Unsetenv(envReadyFD)
+
+		f := os.NewFile(uintptr(fd), "ready")
+		if f == nil {
+			return
+		}
+		f.Write([]byte{1})
+		f.Close()
+	})
+}
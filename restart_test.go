@@ -0,0 +1,124 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRestartChild is not a real test.
+// It is run as the new process by TestApp_Restart.
+func TestRestartChild(t *testing.T) {
+	addr := os.Getenv("LAMBIQUE_TEST_RESTART_ADDR")
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("child"))
+		time.AfterFunc(100*time.Millisecond, func() {
+			os.Exit(0)
+		})
+	})
+	app := &App{Mux: mux, Config: defaultConfig()}
+
+	time.AfterFunc(5*time.Second, func() {
+		os.Exit(0)
+	})
+	app.Start(addr)
+}
+
+func TestApp_Restart(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("parent"))
+	})
+	app := &App{Mux: mux, Config: defaultConfig()}
+	rs, err := app.serverOn("", addr, ln, mux, &app.config().ServerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.track(rs)
+	done := make(chan error, 1)
+	go func() {
+		done <- app.run(rs)
+	}()
+
+	if got := get(t, "http://"+addr+"/"); got != "parent" {
+		t.Fatalf("response before restart = %v, want %v", got, "parent")
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{args[0], "-test.run=^TestRestartChild$"}
+	t.Setenv("LAMBIQUE_TEST_RESTART_ADDR", addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = app.Restart(ctx)
+	if err != nil {
+		t.Fatalf("App.Restart() error = %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Errorf("App.run() error = %v, want %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the parent server is not shut down")
+	}
+
+	if got := get(t, "http://"+addr+"/"); got != "child" {
+		t.Errorf("response after restart = %v, want %v", got, "child")
+	}
+}
+
+func TestApp_Restart_notRunning(t *testing.T) {
+	app := &App{Mux: http.NewServeMux(), Config: defaultConfig()}
+	err := app.Restart(context.Background())
+	if err == nil {
+		t.Errorf("App.Restart() without running servers succeeded, want error")
+	}
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
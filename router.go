@@ -0,0 +1,513 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router is a request router matching the method and the path.
+//
+// A pattern is a path whose segments are either literal, "{name}" matching
+// a single segment, or "{name...}" matching the rest of the path as the last
+// segment. Literal segments take precedence over parameters.
+// The values are available by http.Request.PathValue and the matched
+// pattern by http.Request.Pattern.
+//
+// Unmatched paths are served by NotFound, and paths matched only with other
+// methods are served by MethodNotAllowed with the Allow header.
+type Router struct {
+	// NotFound and MethodNotAllowed handle unmatched requests.
+	// NotFoundHandler and MethodNotAllowedHandler are used if nil.
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+
+	mu         sync.RWMutex
+	middleware Chain
+	routes     []*Route
+	named      map[string]*Route
+}
+
+// NewRouter creates a new router.
+// The zero value is also ready to use.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+func (rt *Router) root() *RouteGroup {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return &RouteGroup{router: rt, middleware: append(Chain{}, rt.middleware...)}
+}
+
+// Use appends the middleware to the routes registered after the call,
+// including the ones of the groups created after the call.
+// They run after the route is matched, so that http.Request.PathValue and
+// http.Request.Pattern are available.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// Routes returns the registered routes in the order of registration.
+func (rt *Router) Routes() []*Route {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return append([]*Route{}, rt.routes...)
+}
+
+// Route is a route registered to a router.
+type Route struct {
+	router   *Router
+	name     string
+	method   string
+	pattern  string
+	segments []segment
+	chain    Chain
+	handler  http.Handler
+}
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal or parameter name
+}
+
+// Name names the route for Router.URL.
+// It panics if the name is already used.
+func (route *Route) Name(name string) *Route {
+	rt := route.router
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if _, ok := rt.named[name]; ok {
+		panic(fmt.Sprintf("lambique: route %s is already named", name))
+	}
+	if rt.named == nil {
+		rt.named = map[string]*Route{}
+	}
+	route.name = name
+	rt.named[name] = route
+	return route
+}
+
+// Method returns the method of the route, or "" for any method.
+func (route *Route) Method() string {
+	return route.method
+}
+
+// Pattern returns the full pattern of the route.
+func (route *Route) Pattern() string {
+	return route.pattern
+}
+
+// RouteName returns the name given by Route.Name.
+func (route *Route) RouteName() string {
+	return route.name
+}
+
+// Chain returns the middleware of the route from the router and the groups.
+func (route *Route) Chain() Chain {
+	return append(Chain{}, route.chain...)
+}
+
+// RouteGroup registers routes with a shared prefix and middleware.
+type RouteGroup struct {
+	router     *Router
+	prefix     string
+	middleware Chain
+}
+
+// Use appends the middleware to the routes registered to g after the call,
+// including the ones of the nested groups created after the call.
+func (g *RouteGroup) Use(mw ...Middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+// Group creates a route group with the prefix and the middleware.
+func (rt *Router) Group(prefix string, mw ...Middleware) *RouteGroup {
+	return rt.root().Group(prefix, mw...)
+}
+
+// Group creates a nested route group.
+// The prefix and the middleware are appended to the ones of g.
+func (g *RouteGroup) Group(prefix string, mw ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:     g.router,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: append(append(Chain{}, g.middleware...), mw...),
+	}
+}
+
+// Handle registers the handler for the method and the pattern.
+// The empty method matches any method.
+// It panics if the pattern is invalid or already registered for the method.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(method, pattern, handler)
+}
+
+// HandleFunc registers the handler function for the method and the pattern.
+func (rt *Router) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) *Route {
+	return rt.root().Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// Get registers the handler for GET, which also serves HEAD.
+func (rt *Router) Get(pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(http.MethodGet, pattern, handler)
+}
+
+// Post registers the handler for POST.
+func (rt *Router) Post(pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(http.MethodPost, pattern, handler)
+}
+
+// Put registers the handler for PUT.
+func (rt *Router) Put(pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(http.MethodPut, pattern, handler)
+}
+
+// Patch registers the handler for PATCH.
+func (rt *Router) Patch(pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(http.MethodPatch, pattern, handler)
+}
+
+// Delete registers the handler for DELETE.
+func (rt *Router) Delete(pattern string, handler http.Handler) *Route {
+	return rt.root().Handle(http.MethodDelete, pattern, handler)
+}
+
+// Handle registers the handler with the prefix and the middleware of g.
+func (g *RouteGroup) Handle(method, pattern string, handler http.Handler) *Route {
+	pattern = g.prefix + pattern
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	chain := append(Chain{}, g.middleware...)
+	route := &Route{
+		router:   g.router,
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		chain:    chain,
+		handler:  chain.Then(handler),
+	}
+
+	rt := g.router
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, r := range rt.routes {
+		if r.method == method && samePattern(r.segments, segments) {
+			panic(fmt.Sprintf("lambique: route %s %s conflicts with %s", method, pattern, r.pattern))
+		}
+	}
+	rt.routes = append(rt.routes, route)
+	return route
+}
+
+// HandleFunc registers the handler function with the prefix and the
+// middleware of g.
+func (g *RouteGroup) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) *Route {
+	return g.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// Get registers the handler for GET, which also serves HEAD.
+func (g *RouteGroup) Get(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodGet, pattern, handler)
+}
+
+// Post registers the handler for POST.
+func (g *RouteGroup) Post(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodPost, pattern, handler)
+}
+
+// Put registers the handler for PUT.
+func (g *RouteGroup) Put(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodPut, pattern, handler)
+}
+
+// Patch registers the handler for PATCH.
+func (g *RouteGroup) Patch(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodPatch, pattern, handler)
+}
+
+// Delete registers the handler for DELETE.
+func (g *RouteGroup) Delete(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodDelete, pattern, handler)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("lambique: pattern %q does not start with /", pattern)
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, len(parts))
+	names := map[string]bool{}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("lambique: pattern %q has an invalid segment %q", pattern, part)
+			}
+			segments[i] = segment{kind: literalSegment, value: part}
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := paramSegment
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("lambique: pattern %q has %s not at the end", pattern, part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = wildcardSegment
+		}
+		if name == "" || strings.ContainsAny(name, "{}.") {
+			return nil, fmt.Errorf("lambique: pattern %q has an invalid segment %q", pattern, part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("lambique: pattern %q has duplicate parameter %s", pattern, name)
+		}
+		names[name] = true
+		segments[i] = segment{kind: kind, value: name}
+	}
+	return segments, nil
+}
+
+func samePattern(a, b []segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind || (a[i].kind == literalSegment && a[i].value != b[i].value) {
+			return false
+		}
+	}
+	return true
+}
+
+// match matches the path and returns the parameters.
+func (route *Route) match(parts []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range route.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case literalSegment:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+			continue
+		case paramSegment:
+			if parts[i] == "" {
+				return nil, false
+			}
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		if seg.kind == wildcardSegment {
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		params[seg.value] = parts[i]
+	}
+	return params, len(parts) == len(route.segments)
+}
+
+// moreSpecific reports whether a is more specific than b.
+func (route *Route) moreSpecific(other *Route) bool {
+	for i := 0; i < len(route.segments) && i < len(other.segments); i++ {
+		if a, b := route.segments[i].kind, other.segments[i].kind; a != b {
+			return a < b
+		}
+	}
+	return len(route.segments) > len(other.segments)
+}
+
+func (route *Route) allows(method string) bool {
+	return route.method == "" || route.method == method ||
+		(route.method == http.MethodGet && method == http.MethodHead)
+}
+
+// ServeHTTP dispatches the request to the handler of the matched route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.serve(w, r, nil, nil)
+}
+
+// serve is ServeHTTP serving the unmatched requests with notFound and
+// methodNotAllowed if Router.NotFound and Router.MethodNotAllowed are nil.
+// App passes its own handlers.
+func (rt *Router) serve(w http.ResponseWriter, r *http.Request, notFound, methodNotAllowed http.Handler) {
+	route, params, allow := rt.lookup(r)
+	if route == nil {
+		rt.unmatched(allow, notFound, methodNotAllowed).ServeHTTP(w, r)
+		return
+	}
+	setPattern(r, route.pattern)
+	for name, value := range params {
+		r.SetPathValue(name, value)
+	}
+	route.handler.ServeHTTP(w, r)
+}
+
+// Handler returns the handler and the pattern of the route matching the
+// request without serving it, like http.ServeMux.Handler.
+// The pattern is "" if no route matches.
+func (rt *Router) Handler(r *http.Request) (http.Handler, string) {
+	route, _, allow := rt.lookup(r)
+	if route == nil {
+		return rt.unmatched(allow, nil, nil), ""
+	}
+	return route.handler, route.pattern
+}
+
+// lookup returns the most specific route matching the request with the
+// parameters, or the methods allowed for the path if no route matches.
+func (rt *Router) lookup(r *http.Request) (*Route, map[string]string, []string) {
+	// split the escaped path so that an escaped slash stays in a segment
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		if p, err := url.PathUnescape(part); err == nil {
+			parts[i] = p
+		}
+	}
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var (
+		best       *Route
+		bestParams map[string]string
+		allow      []string
+	)
+	for _, route := range rt.routes {
+		params, ok := route.match(parts)
+		if !ok {
+			continue
+		}
+		if !route.allows(r.Method) {
+			allow = append(allow, route.method)
+			continue
+		}
+		if best == nil || route.moreSpecific(best) || (route.method != "" && best.method == "" && samePattern(route.segments, best.segments)) {
+			best = route
+			bestParams = params
+		}
+	}
+	return best, bestParams, allow
+}
+
+// unmatched returns the handler of the unmatched requests.
+// The path is matched with the other methods if allow is not empty.
+// notFound and methodNotAllowed are the fallbacks of the handlers of rt.
+func (rt *Router) unmatched(allow []string, notFound, methodNotAllowed http.Handler) http.Handler {
+	if len(allow) == 0 {
+		switch {
+		case rt.NotFound != nil:
+			return rt.NotFound
+		case notFound != nil:
+			return notFound
+		}
+		return NotFoundHandler()
+	}
+
+	h := rt.MethodNotAllowed
+	if h == nil {
+		h = methodNotAllowed
+	}
+	if h == nil {
+		h = MethodNotAllowedHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allowHeader(allow))
+		h.ServeHTTP(w, r)
+	})
+}
+
+func allowHeader(methods []string) string {
+	set := map[string]bool{}
+	for _, m := range methods {
+		set[m] = true
+		if m == http.MethodGet {
+			set[http.MethodHead] = true
+		}
+	}
+	var allow []string
+	for m := range set {
+		allow = append(allow, m)
+	}
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+// URL builds the URL of the named route with the parameters given as
+// name and value pairs. The URL has only the path, so that pagination can
+// add the query, and it can be resolved against the request URL.
+func (rt *Router) URL(name string, pairs ...string) (*url.URL, error) {
+	rt.mu.RLock()
+	route, ok := rt.named[name]
+	rt.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("lambique: no route named %s", name)
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("lambique: odd number of parameters for route %s", name)
+	}
+	params := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		params[pairs[i]] = pairs[i+1]
+	}
+
+	var path, rawPath strings.Builder
+	for _, seg := range route.segments {
+		path.WriteString("/")
+		rawPath.WriteString("/")
+		if seg.kind == literalSegment {
+			path.WriteString(seg.value)
+			rawPath.WriteString(url.PathEscape(seg.value))
+			continue
+		}
+
+		value, ok := params[seg.value]
+		if !ok || (seg.kind == paramSegment && value == "") {
+			return nil, fmt.Errorf("lambique: missing parameter %s for route %s", seg.value, name)
+		}
+		path.WriteString(value)
+		if seg.kind == wildcardSegment {
+			escaped := strings.Split(value, "/")
+			for i := range escaped {
+				escaped[i] = url.PathEscape(escaped[i])
+			}
+			rawPath.WriteString(strings.Join(escaped, "/"))
+		} else {
+			rawPath.WriteString(url.PathEscape(value))
+		}
+	}
+	return &url.URL{Path: path.String(), RawPath: rawPath.String()}, nil
+}
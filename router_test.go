@@ -0,0 +1,178 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	echo := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s id=%s path=%s", name, r.Pattern, r.PathValue("id"), r.PathValue("path"))
+		})
+	}
+	header := func(key, value string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(key, value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	rt := NewRouter()
+	rt.Get("/", echo("root"))
+	rt.Get("/users", echo("list"))
+	rt.Post("/users", echo("create"))
+	rt.Get("/users/{id}", echo("show"))
+	rt.Get("/users/me", echo("me"))
+	rt.Handle("", "/files/{path...}", echo("files"))
+	api := rt.Group("/api/", header("X-Group", "api"))
+	v1 := api.Group("/v1", header("X-Group", "v1"))
+	v1.Delete("/items/{id}", echo("delete"))
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+		wantGroup  []string
+	}{
+		{"GET", "/", http.StatusOK, "root / id= path=", "", nil},
+		{"GET", "/users", http.StatusOK, "list /users id= path=", "", nil},
+		{"HEAD", "/users", http.StatusOK, "list /users id= path=", "", nil},
+		{"POST", "/users", http.StatusOK, "create /users id= path=", "", nil},
+		{"GET", "/users/42", http.StatusOK, "show /users/{id} id=42 path=", "", nil},
+		{"GET", "/users/me", http.StatusOK, "me /users/me id= path=", "", nil},
+		{"GET", "/users/a%2Fb", http.StatusOK, "show /users/{id} id=a/b path=", "", nil},
+		{"PUT", "/files/a/b.txt", http.StatusOK, "files /files/{path...} id= path=a/b.txt", "", nil},
+		{"DELETE", "/api/v1/items/7", http.StatusOK, "delete /api/v1/items/{id} id=7 path=", "", []string{"api", "v1"}},
+		{"GET", "/users/", http.StatusNotFound, "", "", nil},
+		{"GET", "/posts", http.StatusNotFound, "", "", nil},
+		{"DELETE", "/users", http.StatusMethodNotAllowed, "", "GET, HEAD, POST", nil},
+		{"GET", "/api/v1/items/7", http.StatusMethodNotAllowed, "", "DELETE", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if got := rec.Body.String(); got != tt.wantBody {
+					t.Errorf("body = %v, want %v", got, tt.wantBody)
+				}
+			} else if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("Content-Type = %v, want application/problem+json", got)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %v, want %v", got, tt.wantAllow)
+			}
+			if got := rec.Header()["X-Group"]; fmt.Sprint(got) != fmt.Sprint(tt.wantGroup) {
+				t.Errorf("X-Group = %v, want %v", got, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestRouter_Handle_panics(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{"no slash", "users"},
+		{"partial parameter", "/users/id-{id}"},
+		{"empty parameter", "/users/{}"},
+		{"wildcard not at end", "/files/{path...}/raw"},
+		{"duplicate parameter", "/users/{id}/posts/{id}"},
+		{"conflict", "/users/{name}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rt Router
+			rt.Get("/users/{id}", textHandler("user"))
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Handle(%q) does not panic", tt.pattern)
+				}
+			}()
+			rt.Get(tt.pattern, textHandler(""))
+		})
+	}
+}
+
+func TestRouter_URL(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}/posts", textHandler("")).Name("user-posts")
+	rt.Get("/files/{path...}", textHandler("")).Name("file")
+
+	tests := []struct {
+		name    string
+		route   string
+		pairs   []string
+		want    string
+		wantErr bool
+	}{
+		{"params", "user-posts", []string{"id", "42"}, "/users/42/posts", false},
+		{"escaped", "user-posts", []string{"id", "a/b c"}, "/users/a%2Fb%20c/posts", false},
+		{"wildcard", "file", []string{"path", "docs/read me.txt"}, "/files/docs/read%20me.txt", false},
+		{"missing", "user-posts", nil, "", true},
+		{"odd", "user-posts", []string{"id"}, "", true},
+		{"unknown", "nothing", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := rt.URL(tt.route, tt.pairs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("URL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && u.String() != tt.want {
+				t.Errorf("URL() = %v, want %v", u, tt.want)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		u, err := rt.URL("user-posts", "id", "42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.RawQuery = "page=2"
+		links, err := NewPageNumberPagination().PagingLinks(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `</users/42/posts?page=1>; rel="first",</users/42/posts?page=1>; rel="prev",</users/42/posts?page=3>; rel="next"`
+		if got := links.String(); got != want {
+			t.Errorf("PagingLinks() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Name() does not panic")
+			}
+		}()
+		rt.Post("/users", textHandler("")).Name("file")
+	})
+}
@@ -0,0 +1,136 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Errors classifying the causes.
+// They can be compared with errors.Is to the errors created by the
+// functions of the same names, e.g. errors.Is(NotFound(err), ErrNotFound).
+var (
+	ErrBadRequest   error = &StatusError{Status: http.StatusBadRequest}
+	ErrUnauthorized error = &StatusError{Status: http.StatusUnauthorized}
+	ErrForbidden    error = &StatusError{Status: http.StatusForbidden}
+	ErrNotFound     error = &StatusError{Status: http.StatusNotFound}
+	ErrConflict     error = &StatusError{Status: http.StatusConflict}
+	ErrValidation   error = &StatusError{Status: http.StatusUnprocessableEntity}
+	ErrRateLimited  error = &StatusError{Status: http.StatusTooManyRequests}
+	ErrUnavailable  error = &StatusError{Status: http.StatusServiceUnavailable}
+)
+
+// StatusCoder is implemented by errors which declare their HTTP status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// StatusError is an error with a HTTP status code.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+// WithStatus annotates err with the HTTP status code.
+// It returns nil if err is nil, so that a successful result stays a success.
+func WithStatus(err error, status int) error {
+	if err == nil {
+		return nil
+	}
+	return &StatusError{
+		Status: status,
+		Err:    err,
+	}
+}
+
+// annotate annotates err with the status of the sentinel error, or returns
+// the sentinel itself if err is nil, so that NotFound(nil) is still an error.
+func annotate(err, sentinel error) error {
+	if err == nil {
+		return sentinel
+	}
+	return WithStatus(err, sentinel.(*StatusError).Status)
+}
+
+// BadRequest annotates err as a bad request,
+// or returns ErrBadRequest if err is nil.
+func BadRequest(err error) error { return annotate(err, ErrBadRequest) }
+
+// Unauthorized annotates err as an unauthenticated request,
+// or returns ErrUnauthorized if err is nil.
+func Unauthorized(err error) error { return annotate(err, ErrUnauthorized) }
+
+// Forbidden annotates err as a forbidden request,
+// or returns ErrForbidden if err is nil.
+func Forbidden(err error) error { return annotate(err, ErrForbidden) }
+
+// NotFound annotates err as a missing resource,
+// or returns ErrNotFound if err is nil.
+func NotFound(err error) error { return annotate(err, ErrNotFound) }
+
+// Conflict annotates err as a conflict with the current state of the resource,
+// or returns ErrConflict if err is nil.
+func Conflict(err error) error { return annotate(err, ErrConflict) }
+
+// Validation annotates err as an invalid request content,
+// or returns ErrValidation if err is nil.
+func Validation(err error) error { return annotate(err, ErrValidation) }
+
+// RateLimited annotates err as too many requests,
+// or returns ErrRateLimited if err is nil.
+func RateLimited(err error) error { return annotate(err, ErrRateLimited) }
+
+// Unavailable annotates err as a temporary unavailability,
+// or returns ErrUnavailable if err is nil.
+func Unavailable(err error) error { return annotate(err, ErrUnavailable) }
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status code.
+func (e *StatusError) StatusCode() int {
+	return e.Status
+}
+
+// Is reports whether target is the classifying error of the same status.
+func (e *StatusError) Is(target error) bool {
+	t, ok := target.(*StatusError)
+	return ok && t.Err == nil && t.Status == e.Status
+}
+
+// StatusOf returns the HTTP status code for err.
+// It is the status declared by err or the errors it wraps, or the default
+// status of the problem type declared by them.
+// It returns 500 for unknown errors.
+func StatusOf(err error) int {
+	var coder StatusCoder
+	if errors.As(err, &coder) && coder.StatusCode() != 0 {
+		return coder.StatusCode()
+	}
+	if pt, ok := problemTypeOf(err); ok && pt.Status != 0 {
+		return pt.Status
+	}
+	return http.StatusInternalServerError
+}
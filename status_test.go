@@ -0,0 +1,80 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"sentinel", ErrNotFound, http.StatusNotFound},
+		{"wrapped sentinel", fmt.Errorf("user 42: %w", ErrConflict), http.StatusConflict},
+		{"annotated", Unauthorized(errors.New("token expired")), http.StatusUnauthorized},
+		{"wrapped annotated", fmt.Errorf("login: %w", RateLimited(errors.New("slow down"))), http.StatusTooManyRequests},
+		{"outermost wins", Forbidden(NotFound(errors.New("hidden"))), http.StatusForbidden},
+		{"problem type", testOutOfCreditType.Errorf("balance is 30"), http.StatusForbidden},
+		{"unknown", errors.New("sql: no rows in result set"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusOf(tt.err); got != tt.want {
+				t.Errorf("StatusOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusError_Is(t *testing.T) {
+	cause := errors.New("no such user")
+	err := fmt.Errorf("lookup: %w", NotFound(cause))
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(err, ErrNotFound) = false, want true")
+	}
+	if errors.Is(err, ErrConflict) {
+		t.Errorf("errors.Is(err, ErrConflict) = true, want false")
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(err, cause) = false, want true")
+	}
+}
+
+func TestWithStatus_nil(t *testing.T) {
+	tests := []struct {
+		annotate func(error) error
+		want     error
+	}{
+		{BadRequest, ErrBadRequest},
+		{NotFound, ErrNotFound},
+		{Conflict, ErrConflict},
+		{Unavailable, ErrUnavailable},
+	}
+	for _, tt := range tests {
+		if err := tt.annotate(nil); err != tt.want {
+			t.Errorf("%s(nil) = %v, want %v", funcName(tt.annotate), err, tt.want)
+		}
+	}
+	if err := WithStatus(nil, http.StatusTeapot); err != nil {
+		t.Errorf("WithStatus(nil) = %v, want nil", err)
+	}
+}
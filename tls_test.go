@@ -0,0 +1,291 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert generates a certificate for 127.0.0.1 signed by parent.
+// It is self-signed if parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err := ioutil.WriteFile(certFile, c.certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, c.keyPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSConfig_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, ca.certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		config         TLSConfig
+		wantMinVersion uint16
+		wantClientAuth tls.ClientAuthType
+		wantErr        bool
+	}{
+		{
+			name:           "defaults",
+			config:         TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			wantMinVersion: tls.VersionTLS12,
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:           "min version",
+			config:         TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"},
+			wantMinVersion: tls.VersionTLS13,
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:           "client CA",
+			config:         TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			wantMinVersion: tls.VersionTLS12,
+			wantClientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:    "unknown version",
+			config:  TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "2.0"},
+			wantErr: true,
+		},
+		{
+			name:    "insecure cipher suite",
+			config:  TLSConfig{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing files",
+			config:  TLSConfig{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.Load()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TLSConfig.Load() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.MinVersion != tt.wantMinVersion {
+				t.Errorf("TLSConfig.Load().MinVersion = %v, want %v", got.MinVersion, tt.wantMinVersion)
+			}
+			if got.ClientAuth != tt.wantClientAuth {
+				t.Errorf("TLSConfig.Load().ClientAuth = %v, want %v", got.ClientAuth, tt.wantClientAuth)
+			}
+		})
+	}
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := newTestCert(t, "first", nil)
+	certFile, keyFile := first.writeFiles(t, dir)
+
+	r, err := newCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.Subject.CommonName != "first" {
+		t.Errorf("certReloader.GetCertificate() CN = %v, want %v", got.Leaf.Subject.CommonName, "first")
+	}
+
+	// rotate
+	second := newTestCert(t, "second", nil)
+	second.writeFiles(t, dir)
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		err = os.Chtimes(name, future, future)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err = r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.Subject.CommonName != "second" {
+		t.Errorf("certReloader.GetCertificate() CN = %v, want %v", got.Leaf.Subject.CommonName, "second")
+	}
+}
+
+func TestApp_Serve_mutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, ca.certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig()
+	c.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	})
+	app := &App{Mux: mux, Config: c}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Serve(ln)
+	defer ln.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	url := "https://" + ln.Addr().String() + "/"
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{newTestCert(t, "client", ca).tlsCertificate(t)},
+		},
+	}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "client" {
+		t.Errorf("peer CN = %v, want %v", string(body), "client")
+	}
+
+	anonymous := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	_, err = anonymous.Get(url)
+	if err == nil {
+		t.Errorf("request without client certificate succeeded, want error")
+	}
+}
@@ -0,0 +1,405 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of the W3C Trace Context.
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// maxTracestateMembers is the max number of list members in tracestate.
+const maxTracestateMembers = 32
+
+// TraceID is the ID of a trace.
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zero.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID is the ID of a span.
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zero.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span propagated across processes.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// Sampled reports whether the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&0x01 != 0
+}
+
+// IsValid reports whether both the IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a traceparent header of version 00.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header.
+// Fields added by future versions are ignored.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	invalid := fmt.Errorf("lambique: invalid traceparent %q", s)
+
+	// version-traceid-parentid-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, invalid
+	}
+	version, ok := decodeLowerHex(s[:2], 1)
+	if !ok || version[0] == 0xff {
+		return sc, invalid
+	}
+	if len(s) > 55 && (version[0] == 0 || s[55] != '-') {
+		return sc, invalid
+	}
+	traceID, ok := decodeLowerHex(s[3:35], 16)
+	if !ok {
+		return sc, invalid
+	}
+	spanID, ok := decodeLowerHex(s[36:52], 8)
+	if !ok {
+		return sc, invalid
+	}
+	flags, ok := decodeLowerHex(s[53:55], 1)
+	if !ok {
+		return sc, invalid
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, invalid
+	}
+	return sc, nil
+}
+
+func decodeLowerHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// parseTracestate returns the tracestate headers as a single value,
+// or "" if it is invalid so that it is not propagated.
+func parseTracestate(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" {
+				continue
+			}
+			if i := strings.IndexByte(m, '='); i <= 0 || i == len(m)-1 {
+				return ""
+			}
+			members = append(members, m)
+		}
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+// Span is an operation in a trace.
+type Span struct {
+	Name         string                 `json:"name"`
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        bool                   `json:"error,omitempty"`
+
+	context SpanContext
+}
+
+// SpanContext returns the span context to propagate.
+func (s *Span) SpanContext() SpanContext {
+	return s.context
+}
+
+// SetAttribute sets the attribute of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s.Attributes == nil {
+		s.Attributes = map[string]interface{}{}
+	}
+	s.Attributes[key] = value
+}
+
+type spanKey struct{}
+
+// WithSpan returns a copy of ctx with the span.
+func WithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// GetSpan returns the span in ctx, or nil if there is none.
+func GetSpan(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanExporter exports the ended spans.
+type SpanExporter interface {
+	ExportSpan(ctx context.Context, span *Span) error
+}
+
+// StdoutExporter is a SpanExporter writing the spans as JSON lines.
+type StdoutExporter struct {
+	// W is the destination. os.Stdout is used if nil.
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// ExportSpan writes the span as a JSON line.
+func (e *StdoutExporter) ExportSpan(ctx context.Context, span *Span) error {
+	b, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := e.W
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// InMemoryExporter is a SpanExporter keeping the spans in memory for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan appends the span.
+func (e *InMemoryExporter) ExportSpan(ctx context.Context, span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns the exported spans.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+// Reset discards the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// TracingConfig is a config for tracing.
+type TracingConfig struct {
+	// Enabled creates a span for each request to the main server.
+	Enabled bool `toml:"enabled"`
+
+	// Exporter is "stdout" or "none". The default is "stdout".
+	// It is ignored if App.SpanExporter is set.
+	Exporter string `toml:"exporter"`
+}
+
+// NewExporter creates the exporter of the config.
+// It returns nil for "none" to propagate the traces without exporting.
+func (c *TracingConfig) NewExporter() (SpanExporter, error) {
+	switch c.Exporter {
+	case "", "stdout":
+		return &StdoutExporter{}, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("lambique: unknown span exporter %s", c.Exporter)
+	}
+}
+
+// spanExporter returns the exporter of the application.
+// It must be called with app.mu held.
+func (app *App) spanExporter() SpanExporter {
+	if app.SpanExporter == nil {
+		exporter, err := app.config().Tracing.NewExporter()
+		if err != nil {
+			log.Printf("lambique: %v; spans are not exported", err)
+			return nil
+		}
+		app.SpanExporter = exporter
+	}
+	return app.SpanExporter
+}
+
+// Trace returns a middleware creating a server span for each request.
+// The span continues the trace in the traceparent and tracestate headers
+// of the request, or starts a new sampled trace.
+// Sampled spans are exported when the response is done.
+func Trace(exporter SpanExporter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := startServerSpan(r)
+			rw := wrapResponseWriter(w)
+			r = r.WithContext(WithSpan(r.Context(), span))
+
+			defer func() {
+				v := recover()
+				status := rw.Status()
+				if v != nil && !rw.wroteHeader {
+					status = http.StatusInternalServerError
+				}
+				endServerSpan(span, r, status, rw.size)
+				if span.context.Sampled() && exporter != nil {
+					if err := exporter.ExportSpan(context.WithoutCancel(r.Context()), span); err != nil {
+						log.Printf("lambique: export span: %v", err)
+					}
+				}
+				if v != nil {
+					panic(v)
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func startServerSpan(r *http.Request) *Span {
+	span := &Span{
+		Kind:  "server",
+		Start: time.Now(),
+	}
+
+	parent, err := ParseTraceparent(r.Header.Get(traceparentHeader))
+	if err == nil {
+		span.context = parent
+		span.context.TraceState = parseTracestate(r.Header.Values(tracestateHeader))
+		span.ParentSpanID = parent.SpanID.String()
+	} else {
+		randomID(span.context.TraceID[:])
+		span.context.Flags = 0x01
+	}
+	randomID(span.context.SpanID[:])
+	span.TraceID = span.context.TraceID.String()
+	span.SpanID = span.context.SpanID.String()
+
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	return span
+}
+
+func endServerSpan(span *Span, r *http.Request, status int, size int64) {
+	span.End = time.Now()
+	span.Name = r.Method
+	if pattern := patternOf(r); pattern != "" {
+		span.Name += " " + pattern
+		span.SetAttribute("http.route", pattern)
+	}
+	span.SetAttribute("http.response.status_code", status)
+	span.SetAttribute("http.response.body.size", size)
+	span.Error = status >= 500
+}
+
+func randomID(b []byte) {
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
+
+// TraceTransport is an http.RoundTripper propagating the span in the
+// context of outgoing requests with the traceparent and tracestate headers.
+type TraceTransport struct {
+	// Base is the underlying transport.
+	// http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip sets the headers of the span in the context of the request.
+func (t *TraceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	span := GetSpan(r.Context())
+	if span == nil {
+		return base.RoundTrip(r)
+	}
+	// a RoundTripper must not modify the request
+	r = r.Clone(r.Context())
+	sc := span.SpanContext()
+	r.Header.Set(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		r.Header.Set(tracestateHeader, sc.TraceState)
+	} else {
+		r.Header.Del(tracestateHeader)
+	}
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,227 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"valid", testTraceparent, testTraceparent, false},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"future version", "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like", testTraceparent, false},
+		{"extra fields in 00", testTraceparent + "-00", "", true},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", true},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", true},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", true},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", true},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", true},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && sc.Traceparent() != tt.want {
+				t.Errorf("Traceparent() = %v, want %v", sc.Traceparent(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTrace(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}", HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return NotFound(nil)
+	}))
+
+	tests := []struct {
+		name          string
+		traceparent   string
+		tracestate    string
+		wantTraceID   string
+		wantParent    string
+		wantState     string
+		wantExporting bool
+	}{
+		{
+			name:          "continued",
+			traceparent:   testTraceparent,
+			tracestate:    "congo=t61rcWkgMzE, rojo=00f067aa0ba902b7",
+			wantTraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantParent:    "00f067aa0ba902b7",
+			wantState:     "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7",
+			wantExporting: true,
+		},
+		{
+			name:          "not sampled",
+			traceparent:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			wantTraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantParent:    "00f067aa0ba902b7",
+			wantExporting: false,
+		},
+		{
+			name:          "new",
+			traceparent:   "invalid",
+			tracestate:    "congo=t61rcWkgMzE",
+			wantExporting: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			exporter := &InMemoryExporter{}
+			c := defaultConfig()
+			c.Tracing.Enabled = true
+			c.AccessLog.Enabled = true
+			app := &App{
+				Mux:          rt,
+				Config:       c,
+				AccessLogger: slog.New(slog.NewJSONHandler(&logs, nil)),
+				SpanExporter: exporter,
+			}
+
+			var span *Span
+			app.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					span = GetSpan(r.Context())
+					next.ServeHTTP(w, r)
+				})
+			})
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/users/42", nil)
+			r.Header.Set("traceparent", tt.traceparent)
+			if tt.tracestate != "" {
+				r.Header.Set("tracestate", tt.tracestate)
+			}
+			app.Handler().ServeHTTP(rec, r)
+
+			if span == nil {
+				t.Fatal("no span in the context")
+			}
+			if tt.wantTraceID != "" && span.TraceID != tt.wantTraceID {
+				t.Errorf("TraceID = %v, want %v", span.TraceID, tt.wantTraceID)
+			}
+			if span.SpanID == tt.wantParent || len(span.SpanID) != 16 {
+				t.Errorf("SpanID = %v, want a new ID", span.SpanID)
+			}
+			if span.ParentSpanID != tt.wantParent {
+				t.Errorf("ParentSpanID = %v, want %v", span.ParentSpanID, tt.wantParent)
+			}
+			if got := span.SpanContext().TraceState; got != tt.wantState {
+				t.Errorf("TraceState = %v, want %v", got, tt.wantState)
+			}
+			if span.Name != "GET /users/{id}" {
+				t.Errorf("Name = %v, want %v", span.Name, "GET /users/{id}")
+			}
+			if span.Attributes["http.route"] != "/users/{id}" || span.Attributes["http.response.status_code"] != 404 {
+				t.Errorf("Attributes = %v", span.Attributes)
+			}
+
+			spans := exporter.Spans()
+			if tt.wantExporting != (len(spans) == 1 && spans[0] == span) {
+				t.Errorf("exported spans = %v, want exporting %v", spans, tt.wantExporting)
+			}
+
+			var resp ErrorResponse
+			if err := resp.UnmarshalJSON(rec.Body.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Extensions["trace_id"] != span.TraceID {
+				t.Errorf("trace_id = %v, want %v", resp.Extensions["trace_id"], span.TraceID)
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["trace_id"] != span.TraceID || entry["span_id"] != span.SpanID {
+				t.Errorf("logged trace_id = %v, span_id = %v, want %v, %v", entry["trace_id"], entry["span_id"], span.TraceID, span.SpanID)
+			}
+		})
+	}
+}
+
+func TestTraceTransport(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", testTraceparent)
+	r.Header.Set("tracestate", "rojo=00f067aa0ba902b7")
+	span := startServerSpan(r)
+
+	out, err := http.NewRequestWithContext(WithSpan(context.Background(), span), "GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: &TraceTransport{}}).Do(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanID + "-01"
+	if got.Get("traceparent") != want {
+		t.Errorf("traceparent = %v, want %v", got.Get("traceparent"), want)
+	}
+	if got.Get("tracestate") != "rojo=00f067aa0ba902b7" {
+		t.Errorf("tracestate = %v, want %v", got.Get("tracestate"), "rojo=00f067aa0ba902b7")
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := &StdoutExporter{W: &buf}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", testTraceparent)
+	span := startServerSpan(r)
+	endServerSpan(span, r, http.StatusOK, 0)
+	if err := e.ExportSpan(context.Background(), span); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Errorf("output %q is not a line", buf.String())
+	}
+	var got Span
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TraceID != span.TraceID || got.SpanID != span.SpanID || got.Kind != "server" {
+		t.Errorf("exported %+v, want %+v", got, span)
+	}
+}
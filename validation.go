@@ -0,0 +1,159 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemExtender is implemented by errors which add extension members
+// to ErrorResponse.
+type ProblemExtender interface {
+	ProblemExtensions() map[string]interface{}
+}
+
+// Validator is implemented by request bodies which validate themselves.
+// Validate should return *ValidationError.
+type Validator interface {
+	Validate() error
+}
+
+// InvalidParam is a problem of a request parameter.
+type InvalidParam struct {
+	// Name is the name of a query or form parameter.
+	Name string `json:"name,omitempty"`
+	// Pointer is the JSON pointer to a member of the request body.
+	Pointer string `json:"pointer,omitempty"`
+	Reason  string `json:"reason"`
+	Code    string `json:"code,omitempty"`
+}
+
+// ValidationError is an error of request validation.
+// It is written as ErrorResponse with "invalid-params" member.
+type ValidationError struct {
+	// Status is 400 or 422. A zero value means 422.
+	Status int
+	Params []InvalidParam
+}
+
+// AddParam adds a problem of the query or form parameter.
+func (e *ValidationError) AddParam(name, reason, code string) {
+	e.Params = append(e.Params, InvalidParam{Name: name, Reason: reason, Code: code})
+}
+
+// AddField adds a problem of the member of the request body.
+// pointer is a JSON pointer such as "/items/0/name". See JSONPointer.
+func (e *ValidationError) AddField(pointer, reason, code string) {
+	e.Params = append(e.Params, InvalidParam{Pointer: pointer, Reason: reason, Code: code})
+}
+
+// Err returns e if any problem is added, otherwise nil.
+func (e *ValidationError) Err() error {
+	if len(e.Params) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	for _, p := range e.Params {
+		name := p.Name
+		if name == "" {
+			name = p.Pointer
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", name, p.Reason))
+	}
+	return "invalid parameters: " + strings.Join(problems, "; ")
+}
+
+// StatusCode returns the HTTP status code.
+func (e *ValidationError) StatusCode() int {
+	if e.Status == 0 {
+		return http.StatusUnprocessableEntity
+	}
+	return e.Status
+}
+
+// Is reports whether target is ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ProblemExtensions returns "invalid-params" member.
+func (e *ValidationError) ProblemExtensions() map[string]interface{} {
+	return map[string]interface{}{
+		"invalid-params": e.Params,
+	}
+}
+
+// BindJSON decodes the JSON request body into v and validates it if v is
+// a Validator.
+// A value of a wrong type in the body is reported as *ValidationError with
+// the JSON pointer to the member, and a malformed body as ErrBadRequest.
+func BindJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			verr := &ValidationError{}
+			verr.AddField(fieldPointer(typeErr.Field), fmt.Sprintf("must be %s", typeErr.Type), "type")
+			return verr
+		}
+		return BadRequest(err)
+	}
+
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// JSONPointer returns the JSON pointer to the member of the tokens.
+// e.g. JSONPointer("items", 0, "name") returns "/items/0/name".
+func JSONPointer(tokens ...interface{}) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		switch t := token.(type) {
+		case int:
+			b.WriteString(strconv.Itoa(t))
+		default:
+			s := fmt.Sprint(t)
+			s = strings.Replace(s, "~", "~0", -1)
+			s = strings.Replace(s, "/", "~1", -1)
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// fieldPointer converts the dotted field path of json.UnmarshalTypeError
+// to a JSON pointer.
+func fieldPointer(field string) string {
+	if field == "" {
+		return ""
+	}
+	var tokens []interface{}
+	for _, name := range strings.Split(field, ".") {
+		tokens = append(tokens, name)
+	}
+	return JSONPointer(tokens...)
+}
@@ -0,0 +1,136 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lambique
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testOrder struct {
+	Items []testOrderItem `json:"items"`
+}
+
+type testOrderItem struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+func (o *testOrder) Validate() error {
+	verr := &ValidationError{}
+	if len(o.Items) == 0 {
+		verr.AddField(JSONPointer("items"), "must not be empty", "required")
+	}
+	for i, item := range o.Items {
+		if item.Quantity < 1 {
+			verr.AddField(JSONPointer("items", i, "quantity"), "must be positive", "min")
+		}
+	}
+	return verr.Err()
+}
+
+func TestBindJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantErr    error
+		wantParams []InvalidParam
+	}{
+		{
+			name: "valid",
+			body: `{"items": [{"name": "pen", "quantity": 1}]}`,
+		},
+		{
+			name:    "invalid",
+			body:    `{"items": [{"name": "pen", "quantity": 1}, {"name": "ink", "quantity": 0}]}`,
+			wantErr: ErrValidation,
+			wantParams: []InvalidParam{
+				{Pointer: "/items/1/quantity", Reason: "must be positive", Code: "min"},
+			},
+		},
+		{
+			name:    "wrong type",
+			body:    `{"items": [{"name": "pen", "quantity": "one"}]}`,
+			wantErr: ErrValidation,
+			wantParams: []InvalidParam{
+				{Pointer: "/items/0/quantity", Reason: "must be int", Code: "type"},
+			},
+		},
+		{
+			name:    "malformed",
+			body:    `{"items": [`,
+			wantErr: ErrBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/orders", strings.NewReader(tt.body))
+			err := BindJSON(r, &testOrder{})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("BindJSON() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BindJSON() error = %v, want %v", err, tt.wantErr)
+			}
+			var verr *ValidationError
+			if errors.As(err, &verr) && !reflect.DeepEqual(verr.Params, tt.wantParams) {
+				t.Errorf("BindJSON() params = %#v, want %#v", verr.Params, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestNewErrorResponse_validation(t *testing.T) {
+	verr := &ValidationError{Status: http.StatusBadRequest}
+	verr.AddParam("age", "must be a positive integer", "min")
+	verr.AddField("/color", "must be 'green', 'red' or 'blue'", "enum")
+
+	r := httptest.NewRequest("POST", "/users", nil)
+	b, err := json.Marshal(NewErrorResponse(r, verr, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid parameters: age: must be a positive integer; /color: must be 'green', 'red' or 'blue'","instance":"/users","invalid-params":[{"name":"age","reason":"must be a positive integer","code":"min"},{"pointer":"/color","reason":"must be 'green', 'red' or 'blue'","code":"enum"}]}`
+	if string(b) != want {
+		t.Errorf("json.Marshal(NewErrorResponse()) = %s, want %s", b, want)
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []interface{}
+		want   string
+	}{
+		{"root", nil, ""},
+		{"nested", []interface{}{"items", 0, "name"}, "/items/0/name"},
+		{"escaped", []interface{}{"a/b", "m~n"}, "/a~1b/m~0n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JSONPointer(tt.tokens...); got != tt.want {
+				t.Errorf("JSONPointer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}